@@ -0,0 +1,178 @@
+package aws
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/hashicorp/vault/api"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	ENV_VAULTPAL_AWS_CACHE_DIR = "VAULTPAL_AWS_CACHE_DIR"
+
+	// cached credentials are fetched again, if they expire within this period
+	stsRenewBefore = 5 * time.Minute
+	// cache files are removed after the longest lifetime of STS credentials
+	stsCacheMaxAge = 36 * time.Hour
+	stsCacheExt    = ".cache"
+	stsCacheInfo   = "vaultpal aws sts cache"
+)
+
+// stsCache is the encrypted cache file of the STS credentials of a role. The file is named after the vault address,
+// the accessor of the vault token, the engine and the role, and encrypted with a key derived from the vault token,
+// so only the owner of the token can read it.
+type stsCache struct {
+	file string
+	key  []byte
+}
+
+// newSTSCache looks up the accessor of the token of client and returns the cache of engine and role.
+// The variant tells apart credentials of the role requested with different parameters.
+func newSTSCache(client *api.Client, engine string, role string, variant ...string) (*stsCache, error) {
+	self, err := client.Auth().Token().LookupSelf()
+	if err != nil {
+		return nil, errors.Wrap(err, "error looking up token accessor")
+	}
+	accessor, ok := self.Data["accessor"].(string)
+	if !ok || accessor == "" {
+		return nil, errors.New("token has no accessor")
+	}
+
+	dir, err := stsCacheDir()
+	if err != nil {
+		return nil, err
+	}
+
+	name := sha256.Sum256([]byte(strings.Join(append([]string{client.Address(), accessor, engine, role}, variant...), "\n")))
+	mac := hmac.New(sha256.New, []byte(client.Token()))
+	mac.Write([]byte(stsCacheInfo))
+
+	return &stsCache{
+		file: filepath.Join(dir, hex.EncodeToString(name[:])+stsCacheExt),
+		key:  mac.Sum(nil),
+	}, nil
+}
+
+func stsCacheDir() (string, error) {
+	if envCacheDir := os.Getenv(ENV_VAULTPAL_AWS_CACHE_DIR); envCacheDir != "" {
+		return envCacheDir, nil
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".vaultpal", "aws", "cache"), nil
+}
+
+// read returns the cached credentials, if they are still valid
+func (c *stsCache) read() (creds, bool) {
+	sealed, err := os.ReadFile(c.file)
+	if err != nil {
+		return creds{}, false
+	}
+
+	raw, err := c.open(sealed)
+	if err != nil {
+		log.WithError(err).Debug("cannot decrypt cached sts credentials")
+		return creds{}, false
+	}
+
+	cached := creds{}
+	err = json.Unmarshal(raw, &cached)
+	if err != nil {
+		log.WithError(err).Debug("cannot unmarshal cached sts credentials")
+		return creds{}, false
+	}
+
+	if !time.Now().Add(stsRenewBefore).Before(cached.Expiration) {
+		return creds{}, false
+	}
+	log.WithField("Expiration", cached.Expiration.Format(time.RFC3339)).Debug("using cached sts credentials")
+	return cached, true
+}
+
+// write encrypts the credentials into the cache file and removes cache files of old tokens
+func (c *stsCache) write(session creds) error {
+	err := os.MkdirAll(filepath.Dir(c.file), 0700)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create sts cache dir [%s]", filepath.Dir(c.file))
+	}
+
+	raw, err := json.Marshal(session)
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal sts credentials")
+	}
+	sealed, err := c.seal(raw)
+	if err != nil {
+		return err
+	}
+
+	err = utils.WriteFileAtomic(c.file, sealed, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "cannot write cached sts credentials to [%s]", c.file)
+	}
+
+	pruneSTSCache(filepath.Dir(c.file))
+	return nil
+}
+
+func (c *stsCache) seal(plain []byte) ([]byte, error) {
+	gcm, err := c.gcm()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot generate nonce")
+	}
+	return gcm.Seal(nonce, nonce, plain, nil), nil
+}
+
+func (c *stsCache) open(sealed []byte) ([]byte, error) {
+	gcm, err := c.gcm()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("cache file is truncated")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+func (c *stsCache) gcm() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(c.key)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create cipher")
+	}
+	return cipher.NewGCM(block)
+}
+
+// pruneSTSCache removes cache files, which are older than any STS credentials can be valid
+func pruneSTSCache(dir string) {
+	files, err := filepath.Glob(filepath.Join(dir, "*"+stsCacheExt))
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err == nil && time.Since(fi.ModTime()) > stsCacheMaxAge {
+			_ = os.Remove(f)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package aws
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+const PATH_LOOKUP_SELF = "/v1/auth/token/lookup-self"
+
+func mockLookupSelf(accessor string) u.ServeMockFunc {
+	return func(t *testing.T, w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		sec := api.Secret{Data: map[string]interface{}{
+			"accessor": accessor,
+		}}
+		u.WriteJsonResponse(t, sec, w)
+	}
+}
+
+func TestGetCredsCache(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	_ = os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	_ = os.Setenv(api.EnvVaultToken, "1234")
+	dir := t.TempDir()
+	t.Setenv(ENV_VAULTPAL_AWS_CACHE_DIR, dir)
+
+	m := mockSuccData
+	m.lease_duration = 900
+	requests := 0
+	vm.ServeMocks[PATH_LOOKUP_SELF] = mockLookupSelf("accessor-1")
+	vm.ServeMocks[fmt.Sprintf(PATH_READ_STS_CREDS, m.engine, m.role)] = func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+		requests++
+		m.mockReadSTSCreds(t, w, r)
+	}
+
+	got, err := getCreds(m.engine, m.role, CredentialOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, m.access_key, got.SessionId)
+	assert.Equal(t, 1, requests)
+
+	cached, err := getCreds(m.engine, m.role, CredentialOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, got.SessionId, cached.SessionId)
+	assert.WithinDuration(t, got.Expiration, cached.Expiration, time.Second)
+	assert.Equal(t, 1, requests, "valid credentials are read from the cache")
+
+	_, err = getCreds(m.engine, m.role, CredentialOptions{Refresh: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, requests, "refresh skips the cache")
+
+	_, err = getCreds(m.engine, m.role, CredentialOptions{NoCache: true})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, requests, "no-cache skips the cache")
+
+	// the cache file does not reveal the credentials
+	files, err := filepath.Glob(filepath.Join(dir, "*"+stsCacheExt))
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		raw, err := os.ReadFile(files[0])
+		assert.NoError(t, err)
+		assert.NotContains(t, string(raw), m.access_key)
+	}
+
+	// a new token has another accessor
+	vm.ServeMocks[PATH_LOOKUP_SELF] = mockLookupSelf("accessor-2")
+	_ = os.Setenv(api.EnvVaultToken, "5678")
+	_, err = getCreds(m.engine, m.role, CredentialOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, requests, "credentials are cached per token")
+}
+
+func TestSTSCacheExpired(t *testing.T) {
+	c := &stsCache{file: filepath.Join(t.TempDir(), "sts"+stsCacheExt), key: make([]byte, 32)}
+
+	err := c.write(creds{SessionId: "id", Expiration: time.Now().Add(stsRenewBefore - time.Second)})
+	assert.NoError(t, err)
+	_, ok := c.read()
+	assert.False(t, ok, "credentials expiring soon are not used")
+
+	err = c.write(creds{SessionId: "id", Expiration: time.Now().Add(time.Hour)})
+	assert.NoError(t, err)
+	got, ok := c.read()
+	assert.True(t, ok)
+	assert.Equal(t, "id", got.SessionId)
+
+	other := &stsCache{file: c.file, key: append([]byte{1}, make([]byte, 31)...)}
+	_, ok = other.read()
+	assert.False(t, ok, "the cache cannot be read with another key")
+}
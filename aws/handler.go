@@ -24,35 +24,33 @@ import (
 	"gopkg.in/ini.v1"
 )
 
-var stsExportStr = `export AWS_ACCESS_KEY_ID=%s
-export AWS_SECRET_ACCESS_KEY=%s
-export AWS_SESSION_TOKEN=%s`
-
-var stsCmdEnvStr = `set AWS_ACCESS_KEY_ID=%s
-set AWS_SECRET_ACCESS_KEY=%s
-set AWS_SESSION_TOKEN=%s`
-
-var stsPsEnvStr = `$env:AWS_ACCESS_KEY_ID="%s"
-$env:AWS_SECRET_ACCESS_KEY="%s"
-$env:AWS_SESSION_TOKEN="%s"`
-
 type creds struct {
 	SessionId    string
 	SessionKey   string
 	SessionToken string
+	// Expiration of the credentials, taken from the vault lease
+	Expiration time.Time
+	// LeaseID of the credentials, which is revoked to delete iam_user credentials
+	LeaseID string
 }
 
+// credentialsBackupSuffix is appended to the name of the backup of the credentials file
+const credentialsBackupSuffix = ".bak"
+
 const (
 	errFederationMarshal   = "failed to marshal federation session"
 	errFederationRequest   = "failed to request federation"
 	errFederationResponse  = "failed to receive federation response body"
 	errFederationUnmarshal = "failed to unmarshal sign-in token"
-	defaultEngine          = "aws"
+	defaultSTSTTL          = time.Hour
+	// ENV_AWS_REGION is the region of the AWS SDKs
+	ENV_AWS_REGION = "AWS_REGION"
+	defaultOutput  = "json"
 )
 
-func ExportSTSCredentials(engine string, role string) error {
+func ExportSTSCredentials(engine string, role string, opts CredentialOptions) error {
 
-	exportCmd, err := handleExportSTSCreds(engine, role)
+	exportCmd, err := handleExportSTSCreds(engine, role, opts)
 	if err != nil {
 		// we must make sure, nothing goes to STDOUT
 		//log.Error("Failed: ", err)
@@ -62,42 +60,114 @@ func ExportSTSCredentials(engine string, role string) error {
 	return nil
 }
 
-func handleExportSTSCreds(engine string, role string) (string, error) {
+func handleExportSTSCreds(engine string, role string, opts CredentialOptions) (string, error) {
 
-	creds, err := getCreds(engine, role)
+	creds, err := getCreds(engine, role, opts)
 	if err != nil {
 		return "", err
 	}
-	stsFormatStr := stsExportStr
-	if runtime.GOOS == "windows" {
-		log.Debugln("OS Detected: Running on Windows!")
-		hostShell, err := utils.GetHostShell()
-		if err != nil {
-			log.Errorln("Failed to get host shell: ", err)
-		}
-		log.Debugln("Host Shell: ", hostShell)
-		if hostShell == "cmd" {
-			log.Debugln("Shell Host Detected: Running on CMD!")
-			stsFormatStr = stsCmdEnvStr
-		} else if hostShell == "powershell" {
-			log.Debugln("Shell Host Detected: Running on PowerShell!")
-			stsFormatStr = stsPsEnvStr
-		} else {
-			return "", errors.New("Failed to detect host shell!")
-		}
+	exportCmd, err := utils.ExportEnv(
+		utils.EnvVar{Name: "AWS_ACCESS_KEY_ID", Value: creds.SessionId},
+		utils.EnvVar{Name: "AWS_SECRET_ACCESS_KEY", Value: creds.SessionKey},
+		utils.EnvVar{Name: "AWS_SESSION_TOKEN", Value: creds.SessionToken},
+	)
+	if err != nil {
+		return "", err
 	}
-	exportCmd := fmt.Sprintf(stsFormatStr, creds.SessionId, creds.SessionKey, creds.SessionToken)
 	return exportCmd, nil
 }
 
-func getCreds(engine string, role string) (creds, error) {
+// CredentialOptions controls how the AWS credentials of a role are obtained
+type CredentialOptions struct {
+	// CredentialType is one of CredentialTypes, the credential type of the vault role if empty
+	CredentialType string
+	// NoCache neither reads nor writes the local cache of STS credentials
+	NoCache bool
+	// Refresh fetches new credentials from vault, even if the cached ones are still valid
+	Refresh bool
+	// RoleARN selects one of the role ARNs of the vault role
+	RoleARN string
+	// TTL of the credentials, the default of the vault role if zero. Must not exceed the max_sts_ttl of the role.
+	TTL time.Duration
+	// RoleSessionName of the assumed role session
+	RoleSessionName string
+	// MFACode of the MFA device configured for the vault role
+	MFACode string
+}
+
+func getCreds(engine string, role string, opts CredentialOptions) (creds, error) {
 	client, err := vault.NewClient()
 
 	if err != nil {
 		return creds{}, errors.Wrap(err, "error creating vault api client")
 	}
 
-	secret, err := client.Logical().Read(fmt.Sprintf("%s/sts/%s", engine, role))
+	err = opts.validate()
+	if err != nil {
+		return creds{}, err
+	}
+
+	var cache *stsCache
+	if !opts.NoCache {
+		cache, err = newSTSCache(client, engine, role, opts.cacheVariant()...)
+		if err != nil {
+			log.WithError(err).Debug("sts cache disabled")
+		}
+	}
+	if cache != nil && !opts.Refresh {
+		if cached, ok := cache.read(); ok {
+			return cached, nil
+		}
+	}
+
+	err = validateRole(client, engine, role, opts)
+	if err != nil {
+		return creds{}, err
+	}
+
+	session, err := readCreds(client, engine, role, opts)
+	if err != nil {
+		return creds{}, err
+	}
+
+	cacheFile := ""
+	if cache != nil {
+		err = cache.write(session)
+		if err != nil {
+			log.WithError(err).Warn("cannot cache sts credentials")
+		} else {
+			cacheFile = cache.file
+		}
+	}
+
+	// access keys without session token are iam_user credentials, which stay valid until their lease is revoked
+	if session.LeaseID != "" && session.SessionToken == "" {
+		err = recordLease(Lease{
+			LeaseID:      session.LeaseID,
+			VaultAddress: client.Address(),
+			Engine:       engine,
+			Role:         role,
+			AccessKey:    session.SessionId,
+			Expiration:   session.Expiration,
+			CacheFile:    cacheFile,
+		})
+		if err != nil {
+			log.WithError(err).Warn("cannot record lease of iam_user credentials, revoke it with 'vault lease revoke " + session.LeaseID + "'")
+		}
+	}
+	return session, nil
+}
+
+// readCreds requests new credentials of role from vault, the parameters are sent along, if there are any
+func readCreds(client *api.Client, engine string, role string, opts CredentialOptions) (creds, error) {
+	var secret *api.Secret
+	var err error
+	path := opts.credentialsPath(engine, role)
+	if params := opts.stsParameters(); params == nil {
+		secret, err = client.Logical().Read(path)
+	} else {
+		secret, err = client.Logical().Write(path, params)
+	}
 	if err != nil {
 		return creds{}, errors.Wrap(err, "error reading STS credentials from vault")
 	}
@@ -116,28 +186,46 @@ func getCreds(engine string, role string) (creds, error) {
 		return creds{}, err
 	}
 
-	securityToken, err := vault.GetVerifiedSecretString(secret, "security_token", true)
-	if err != nil {
-		return creds{}, err
+	// iam_user credentials are access keys without session
+	securityToken := ""
+	if opts.CredentialType != CredentialTypeIAMUser {
+		securityToken, err = vault.GetVerifiedSecretString(secret, "security_token", true)
+		if err != nil {
+			return creds{}, err
+		}
 	}
 
 	session := creds{
 		SessionId:    accessKey,
 		SessionKey:   secretKey,
 		SessionToken: securityToken,
+		Expiration:   leaseExpiration(secret),
+		LeaseID:      secret.LeaseID,
 	}
 
 	return session, nil
 }
 
-func GenerateConsoleURL(engine string, suppressBrowser bool, role string) error {
+// leaseExpiration returns the end of the lease of secret. STS credentials without lease are valid for an hour.
+func leaseExpiration(secret *api.Secret) time.Time {
+	lease := defaultSTSTTL
+	if secret.LeaseDuration > 0 {
+		lease = time.Duration(secret.LeaseDuration) * time.Second
+	}
+	return time.Now().Add(lease)
+}
+
+func GenerateConsoleURL(engine string, suppressBrowser bool, role string, opts CredentialOptions) error {
 
-	creds, err := getCreds(engine, role)
+	creds, err := getCreds(engine, role, opts)
 	if err != nil {
 		// we must make sure, nothing goes to STDOUT
 		//log.Error("Failed: ", err)
 		return err
 	}
+	if creds.SessionToken == "" {
+		return errors.Errorf("the aws console requires STS credentials, use another credential type than %s", CredentialTypeIAMUser)
+	}
 
 	signInToken, err := createSignInToken(creds)
 	if err != nil {
@@ -159,8 +247,8 @@ func signInURL(signInToken string) string {
 		destination = "https://console.aws.amazon.com/"
 		issuer      = os.Getenv(api.EnvVaultAddress)
 	)
-	if os.Getenv("AWS_REGION") != "" {
-		destination = fmt.Sprintf("https://%s.console.aws.amazon.com/", os.Getenv("AWS_REGION"))
+	if os.Getenv(ENV_AWS_REGION) != "" {
+		destination = fmt.Sprintf("https://%s.console.aws.amazon.com/", os.Getenv(ENV_AWS_REGION))
 	}
 
 	return fmt.Sprintf("https://signin.aws.amazon.com/federation?Action=login&Issuer=%s&Destination=%s&SigninToken=%s\n",
@@ -232,56 +320,97 @@ func openBrowser(url string) error {
 	}
 }
 
-func WriteAWSCreds(role string, profile string) error {
+// WriteAWSCreds writes the credentials of role into the credentials file and the region and output of profile into
+// the AWS config file. The region is taken from region, AWS_REGION or the vaultpal config.
+func WriteAWSCreds(engine string, role string, profile string, region string, opts CredentialOptions) error {
 
 	filename, err := resolveFilename()
 	if err != nil {
 		return err
 	}
-
-	err = ensureConfigExists(profile)
+	configFilename, err := resolveConfigFilename()
 	if err != nil {
-		return errors.Wrap(err, "unable to locate creds file")
+		return err
 	}
 
-	config, err := ini.Load(filename)
+	creds, err := getCreds(engine, role, opts)
 	if err != nil {
+		// we must make sure, nothing goes to STDOUT
+		//log.Error("Failed: ", err)
 		return err
 	}
-	iniProfile, err := config.NewSection(profile)
+
+	fileOpts := utils.FileUpdateOptions{Perm: 0600, BackupSuffix: credentialsBackupSuffix}
+	region = resolveRegion(region)
+	err = utils.UpdateFile(filename, fileOpts, func(old []byte) ([]byte, error) {
+		return renderAWSCreds(old, profile, creds, region)
+	})
 	if err != nil {
-		return err
+		return errors.Wrap(err, "unable to write creds file")
 	}
 
-	creds, err := getCreds(defaultEngine, role)
+	fileOpts.BackupSuffix = configBackupSuffix
+	err = utils.UpdateFile(configFilename, fileOpts, func(old []byte) ([]byte, error) {
+		return renderProfileSettings(old, profile, region, config2.GetAWSOutput())
+	})
 	if err != nil {
-		// we must make sure, nothing goes to STDOUT
-		//log.Error("Failed: ", err)
-		return err
+		return errors.Wrap(err, "unable to write aws config file")
+	}
+	if region == "" {
+		log.WithField("Profile", profile).Warn("no region configured for the profile, pass --region or set AWS_REGION")
+	}
+
+	log.Infof("AWS creds written to: %s \n Use them with `aws --profile %s sts get-caller-identity`", filename, profile)
+
+	return nil
+}
+
+// resolveRegion returns region, or the region of the environment or the vaultpal config, if it is empty
+func resolveRegion(region string) string {
+	if region != "" {
+		return region
+	}
+	if envRegion := os.Getenv(ENV_AWS_REGION); envRegion != "" {
+		return envRegion
+	}
+	return config2.GetAWSRegion()
+}
+
+// renderAWSCreds sets the credentials of profile in the content of the credentials file
+func renderAWSCreds(old []byte, profile string, sts creds, region string) ([]byte, error) {
+	config := ini.Empty()
+	if len(old) > 0 {
+		var err error
+		config, err = ini.Load(old)
+		if err != nil {
+			return nil, err
+		}
+	}
+	iniProfile, err := config.NewSection(profile)
+	if err != nil {
+		return nil, err
 	}
 
 	test := config2.AWSCredentials{
-		AWSAccessKey:     creds.SessionId,
-		AWSSecretKey:     creds.SessionKey,
-		AWSSessionToken:  creds.SessionToken,
-		AWSSecurityToken: creds.SessionToken,
-		Expires:          time.Now().Local().Add(1 * time.Hour),
-		Region:           "eu-central-1", //maybe drop this
+		AWSAccessKey:     sts.SessionId,
+		AWSSecretKey:     sts.SessionKey,
+		AWSSessionToken:  sts.SessionToken,
+		AWSSecurityToken: sts.SessionToken,
+		Expires:          sts.Expiration.Local(),
+		Region:           region,
 	}
 
 	err = iniProfile.ReflectFrom(&test)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	err = config.SaveTo(filename)
+	var buf bytes.Buffer
+	_, err = config.WriteTo(&buf)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	log.Infof("AWS creds written to: %s \n Use them with `aws --profile %s sts get-caller-identity`", filename, profile)
-
-	return nil
+	return buf.Bytes(), nil
 }
 
 func resolveSymlink(filename string) (string, error) {
@@ -316,31 +445,3 @@ func resolveFilename() (string, error) {
 	}
 	return name, nil
 }
-
-func ensureConfigExists(profile string) error {
-	filename, err := resolveFilename()
-	if err != nil {
-		return err
-	}
-
-	if _, err := os.Stat(filename); err != nil {
-		if os.IsNotExist(err) {
-
-			dir := filepath.Dir(filename)
-
-			err = os.MkdirAll(dir, os.ModePerm)
-			if err != nil {
-				return err
-			}
-
-			// create a base config file
-			err = os.WriteFile(filename, []byte("["+profile+"]"), 0600)
-			if err != nil {
-				return err
-			}
-
-		}
-		return err
-	}
-	return nil
-}
@@ -2,12 +2,16 @@ package aws
 
 import (
 	"fmt"
+	"github.com/dbschenker/vaultpal/config"
 	u "github.com/dbschenker/vaultpal/internal/testutil"
 	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
 	"net/http"
 	"os"
 	"testing"
+	"time"
 )
 
 const (
@@ -20,11 +24,12 @@ type mockData struct {
 	access_key     string
 	secret_key     string
 	security_token string
+	lease_duration int
 }
 
 func (m *mockData) mockReadSTSCreds(t *testing.T, w http.ResponseWriter, _ *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
-	sec := api.Secret{Data: map[string]interface{}{
+	sec := api.Secret{LeaseDuration: m.lease_duration, Data: map[string]interface{}{
 		"access_key":     m.access_key,
 		"secret_key":     m.secret_key,
 		"security_token": m.security_token,
@@ -143,7 +148,7 @@ func TestExportSTSCreds(t *testing.T) {
 	for _, test := range tests {
 		t.Logf("Executing TestCase: %s", test.Name)
 		vm.ServeMocks = test.serveMocks
-		err := ExportSTSCredentials(test.MockData.engine, test.MockData.role)
+		err := ExportSTSCredentials(test.MockData.engine, test.MockData.role, CredentialOptions{NoCache: true})
 		if test.WantErr != "" {
 			assert.EqualError(t, err, test.WantErr)
 		} else if test.WantErrContains != "" {
@@ -165,7 +170,7 @@ func TestReadSTSCreds(t *testing.T) {
 	for _, test := range tests {
 		t.Logf("Executing TestCase: %s", test.Name)
 		vm.ServeMocks = test.serveMocks
-		exportCmd, err := handleExportSTSCreds(test.MockData.engine, test.MockData.role)
+		exportCmd, err := handleExportSTSCreds(test.MockData.engine, test.MockData.role, CredentialOptions{NoCache: true})
 		if test.WantErr != "" {
 			assert.EqualError(t, err, test.WantErr)
 			assert.Empty(t, exportCmd)
@@ -192,3 +197,38 @@ func buildWantExportCmd(data mockData) string {
 export AWS_SECRET_ACCESS_KEY=%s
 export AWS_SESSION_TOKEN=%s`, data.access_key, data.secret_key, data.security_token)
 }
+
+func TestRenderAWSCreds(t *testing.T) {
+	old := []byte("[default]\naws_access_key_id = mine\n\n[np]\naws_access_key_id = stale\n")
+
+	expiration := time.Now().Add(15 * time.Minute).Truncate(time.Second)
+	out, err := renderAWSCreds(old, "np", creds{SessionId: "id", SessionKey: "key", SessionToken: "token", Expiration: expiration}, "eu-west-1")
+	assert.NoError(t, err)
+
+	got, err := ini.Load(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "mine", got.Section("default").Key("aws_access_key_id").String())
+	assert.Equal(t, "id", got.Section("np").Key("aws_access_key_id").String())
+	assert.Equal(t, "token", got.Section("np").Key("aws_session_token").String())
+	assert.Equal(t, "eu-west-1", got.Section("np").Key("region").String())
+	expires, err := got.Section("np").Key("x_security_token_expires").TimeFormat(time.RFC3339)
+	assert.NoError(t, err)
+	assert.True(t, expiration.Equal(expires), "the expiration of the lease is written")
+
+	out, err = renderAWSCreds(nil, "np", creds{SessionId: "id", SessionKey: "key", SessionToken: "token", Expiration: expiration}, "")
+	assert.NoError(t, err)
+	got, err = ini.Load(out)
+	assert.NoError(t, err)
+	assert.False(t, got.Section("np").HasKey("region"))
+}
+
+func TestResolveRegion(t *testing.T) {
+	t.Setenv(ENV_AWS_REGION, "eu-west-1")
+	assert.Equal(t, "us-east-1", resolveRegion("us-east-1"))
+	assert.Equal(t, "eu-west-1", resolveRegion(""))
+
+	t.Setenv(ENV_AWS_REGION, "")
+	viper.Set(config.KeyAWSRegion, "eu-north-1")
+	defer viper.Set(config.KeyAWSRegion, nil)
+	assert.Equal(t, "eu-north-1", resolveRegion(""))
+}
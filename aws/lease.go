@@ -0,0 +1,190 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const ENV_VAULTPAL_AWS_LEASES_FILE = "VAULTPAL_AWS_LEASES_FILE"
+
+// Lease is the local record of iam_user credentials, which are valid until their vault lease is revoked or expires
+type Lease struct {
+	LeaseID      string    `json:"lease_id" yaml:"lease_id"`
+	VaultAddress string    `json:"vault_address" yaml:"vault_address"`
+	Engine       string    `json:"engine" yaml:"engine"`
+	Role         string    `json:"role" yaml:"role"`
+	AccessKey    string    `json:"access_key" yaml:"access_key"`
+	Issued       time.Time `json:"issued" yaml:"issued"`
+	Expiration   time.Time `json:"expiration" yaml:"expiration"`
+	CacheFile    string    `json:"cache_file,omitempty" yaml:"cache_file,omitempty"`
+}
+
+func leasesFile() (string, error) {
+	if envLeasesFile := os.Getenv(ENV_VAULTPAL_AWS_LEASES_FILE); envLeasesFile != "" {
+		return envLeasesFile, nil
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".vaultpal", "aws", "leases.json"), nil
+}
+
+func readLeases() ([]Lease, error) {
+	file, err := leasesFile()
+	if err != nil {
+		return nil, err
+	}
+	raw, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot read leases file [%s]", file)
+	}
+	return parseLeases(raw)
+}
+
+func parseLeases(raw []byte) ([]Lease, error) {
+	leases := []Lease{}
+	if len(raw) == 0 {
+		return leases, nil
+	}
+	err := json.Unmarshal(raw, &leases)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse leases file")
+	}
+	return leases, nil
+}
+
+// updateLeases locks the leases file and replaces its entries by the result of update,
+// the file is left unchanged if update returns nil
+func updateLeases(update func(leases []Lease) ([]Lease, error)) error {
+	file, err := leasesFile()
+	if err != nil {
+		return err
+	}
+	return utils.UpdateFile(file, utils.FileUpdateOptions{Perm: 0600}, func(old []byte) ([]byte, error) {
+		leases, err := parseLeases(old)
+		if err != nil {
+			return nil, err
+		}
+		leases, err = update(leases)
+		if err != nil || leases == nil {
+			return nil, err
+		}
+		return json.MarshalIndent(leases, "", "  ")
+	})
+}
+
+// recordLease adds lease to the leases file and drops the entries of expired leases
+func recordLease(lease Lease) error {
+	if lease.Issued.IsZero() {
+		lease.Issued = time.Now()
+	}
+	return updateLeases(func(leases []Lease) ([]Lease, error) {
+		kept := []Lease{}
+		for _, l := range leases {
+			if l.LeaseID != lease.LeaseID && time.Now().Before(l.Expiration) {
+				kept = append(kept, l)
+			}
+		}
+		return append(kept, lease), nil
+	})
+}
+
+// Leases prints the recorded leases of iam_user credentials
+func Leases(format string) error {
+	leases, err := readLeases()
+	if err != nil {
+		return err
+	}
+	if leases == nil {
+		leases = []Lease{}
+	}
+
+	return utils.WriteOutput(os.Stdout, format, leases, func(w io.Writer) {
+		_, _ = fmt.Fprintln(w, "LEASE\tROLE\tACCESS KEY\tEXPIRES\tVAULT")
+		for _, l := range leases {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", l.LeaseID, l.Role, l.AccessKey, l.Expiration.Format(time.RFC3339), l.VaultAddress)
+		}
+	})
+}
+
+// Revoke revokes a lease of iam_user credentials by its ID, or all recorded leases of a role at the current vault,
+// which deletes the access keys in AWS
+func Revoke(leaseOrRole string) error {
+	client, err := vault.NewClient()
+	if err != nil {
+		return errors.Wrap(err, "error creating vault api client")
+	}
+
+	revoked, err := handleRevoke(client, leaseOrRole)
+	for _, l := range revoked {
+		log.WithFields(log.Fields{
+			"lease":     l.LeaseID,
+			"role":      l.Role,
+			"accessKey": l.AccessKey,
+		}).Info("revoked lease")
+	}
+	return err
+}
+
+func handleRevoke(client *api.Client, leaseOrRole string) ([]Lease, error) {
+	revoked := []Lease{}
+	var revokeErr error
+	err := updateLeases(func(leases []Lease) ([]Lease, error) {
+		matches := []Lease{}
+		kept := []Lease{}
+		for _, l := range leases {
+			if l.LeaseID == leaseOrRole || (l.Role == leaseOrRole && l.VaultAddress == client.Address()) {
+				matches = append(matches, l)
+			} else {
+				kept = append(kept, l)
+			}
+		}
+
+		// lease IDs are paths of the engine, so unrecorded leases are revoked as well
+		if len(matches) == 0 {
+			if !strings.Contains(leaseOrRole, "/") {
+				return nil, errors.Errorf("no lease of role %s at vault %s recorded", leaseOrRole, client.Address())
+			}
+			matches = append(matches, Lease{LeaseID: leaseOrRole, VaultAddress: client.Address()})
+		}
+
+		for _, l := range matches {
+			err := client.Sys().Revoke(l.LeaseID)
+			if err != nil {
+				revokeErr = errors.Wrapf(vault.CheckReachable(client, err), "error revoking lease %s", l.LeaseID)
+				kept = append(kept, l)
+				continue
+			}
+			if l.CacheFile != "" {
+				_ = os.Remove(l.CacheFile)
+			}
+			revoked = append(revoked, l)
+		}
+		// the revoked leases are dropped, even if others failed
+		if len(revoked) == 0 {
+			return nil, nil
+		}
+		return kept, nil
+	})
+	if err != nil {
+		return revoked, err
+	}
+	return revoked, revokeErr
+}
@@ -0,0 +1,143 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	PATH_READ_IAM_CREDS = "/v1/%s/creds/%s"
+	PATH_REVOKE_LEASE   = "/v1/sys/leases/revoke"
+)
+
+func (m *mockData) mockReadIAMCreds(leaseID string) u.ServeMockFunc {
+	return func(t *testing.T, w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		sec := api.Secret{LeaseID: leaseID, LeaseDuration: m.lease_duration, Data: map[string]interface{}{
+			"access_key":     m.access_key,
+			"secret_key":     m.secret_key,
+			"security_token": nil,
+		}}
+		u.WriteJsonResponse(t, sec, w)
+	}
+}
+
+func TestGetCredsIAMUser(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	_ = os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	_ = os.Setenv(api.EnvVaultToken, "1234")
+	t.Setenv(ENV_VAULTPAL_AWS_LEASES_FILE, filepath.Join(t.TempDir(), "leases.json"))
+
+	m := mockSuccData
+	m.lease_duration = 3600
+	leaseID := fmt.Sprintf("%s/creds/%s/abc", m.engine, m.role)
+	vm.ServeMocks[fmt.Sprintf(PATH_READ_IAM_CREDS, m.engine, m.role)] = m.mockReadIAMCreds(leaseID)
+	vm.ServeMocks[fmt.Sprintf(PATH_READ_ROLE, m.engine, m.role)] = func(t *testing.T, w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		u.WriteJsonResponse(t, api.Secret{Data: map[string]interface{}{
+			"credential_type": CredentialTypeIAMUser,
+		}}, w)
+	}
+
+	got, err := getCreds(m.engine, m.role, CredentialOptions{CredentialType: CredentialTypeIAMUser, NoCache: true})
+	assert.NoError(t, err)
+	assert.Equal(t, m.access_key, got.SessionId)
+	assert.Empty(t, got.SessionToken)
+	assert.Equal(t, leaseID, got.LeaseID)
+
+	leases, err := readLeases()
+	assert.NoError(t, err)
+	if assert.Len(t, leases, 1) {
+		assert.Equal(t, leaseID, leases[0].LeaseID)
+		assert.Equal(t, m.role, leases[0].Role)
+		assert.Equal(t, m.access_key, leases[0].AccessKey)
+		assert.Equal(t, vm.Server.URL, leases[0].VaultAddress)
+	}
+
+	_, err = getCreds(m.engine, m.role, CredentialOptions{CredentialType: CredentialTypeIAMUser, NoCache: true, TTL: time.Hour})
+	assert.EqualError(t, err, "role arn, ttl, role session name and mfa code are not supported for credential type iam_user")
+
+	// the credential type must be allowed by the role
+	vm.ServeMocks[fmt.Sprintf(PATH_READ_ROLE, m.engine, m.role)] = mockReadRole(0)
+	_, err = getCreds(m.engine, m.role, CredentialOptions{CredentialType: CredentialTypeIAMUser, NoCache: true})
+	assert.EqualError(t, err, "credential type iam_user is not allowed for role gopher-vpc-manager, must be one of [assumed_role]")
+
+	_, err = getCreds(m.engine, m.role, CredentialOptions{CredentialType: "root", NoCache: true})
+	assert.EqualError(t, err, "unknown credential type [root], must be one of [iam_user assumed_role federation_token session_token]")
+}
+
+func TestRecordLeaseDropsExpired(t *testing.T) {
+	t.Setenv(ENV_VAULTPAL_AWS_LEASES_FILE, filepath.Join(t.TempDir(), "leases.json"))
+
+	assert.NoError(t, recordLease(Lease{LeaseID: "aws/creds/a/1", Expiration: time.Now().Add(-time.Minute)}))
+	assert.NoError(t, recordLease(Lease{LeaseID: "aws/creds/a/2", Expiration: time.Now().Add(time.Hour)}))
+	assert.NoError(t, recordLease(Lease{LeaseID: "aws/creds/a/3", Expiration: time.Now().Add(time.Hour)}))
+
+	leases, err := readLeases()
+	assert.NoError(t, err)
+	ids := []string{}
+	for _, l := range leases {
+		ids = append(ids, l.LeaseID)
+	}
+	assert.Equal(t, []string{"aws/creds/a/2", "aws/creds/a/3"}, ids)
+}
+
+func TestHandleRevoke(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	_ = os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	_ = os.Setenv(api.EnvVaultToken, "1234")
+	dir := t.TempDir()
+	t.Setenv(ENV_VAULTPAL_AWS_LEASES_FILE, filepath.Join(dir, "leases.json"))
+
+	revoked := []string{}
+	vm.ServeMocks[PATH_REVOKE_LEASE] = func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		revoked = append(revoked, body["lease_id"].(string))
+		w.WriteHeader(http.StatusNoContent)
+	}
+
+	cacheFile := filepath.Join(dir, "a.cache")
+	assert.NoError(t, os.WriteFile(cacheFile, []byte("sealed"), 0600))
+	expiration := time.Now().Add(time.Hour)
+	assert.NoError(t, recordLease(Lease{LeaseID: "aws/creds/a/1", VaultAddress: vm.Server.URL, Role: "a", Expiration: expiration, CacheFile: cacheFile}))
+	assert.NoError(t, recordLease(Lease{LeaseID: "aws/creds/a/2", VaultAddress: "https://other.vault", Role: "a", Expiration: expiration}))
+	assert.NoError(t, recordLease(Lease{LeaseID: "aws/creds/b/1", VaultAddress: vm.Server.URL, Role: "b", Expiration: expiration}))
+
+	client, err := api.NewClient(api.DefaultConfig())
+	assert.NoError(t, err)
+
+	// leases of a role are only revoked at the current vault
+	got, err := handleRevoke(client, "a")
+	assert.NoError(t, err)
+	assert.Len(t, got, 1)
+	assert.Equal(t, []string{"aws/creds/a/1"}, revoked)
+	_, err = os.Stat(cacheFile)
+	assert.True(t, os.IsNotExist(err), "cached credentials of the lease are removed")
+
+	_, err = handleRevoke(client, "a")
+	assert.EqualError(t, err, fmt.Sprintf("no lease of role a at vault %s recorded", vm.Server.URL))
+
+	// unrecorded lease IDs are revoked as well
+	_, err = handleRevoke(client, "aws/creds/c/1")
+	assert.NoError(t, err)
+	_, err = handleRevoke(client, "aws/creds/b/1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"aws/creds/a/1", "aws/creds/c/1", "aws/creds/b/1"}, revoked)
+
+	leases, err := readLeases()
+	assert.NoError(t, err)
+	assert.Len(t, leases, 1)
+	assert.Equal(t, "aws/creds/a/2", leases[0].LeaseID)
+}
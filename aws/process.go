@@ -0,0 +1,196 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/ini.v1"
+)
+
+const (
+	// credentialProcessVersion is the version of the credential_process output format of the AWS SDKs
+	credentialProcessVersion = 1
+	// ENV_AWS_CONFIG_FILE overrides the location of the AWS config file, like the AWS SDKs do
+	ENV_AWS_CONFIG_FILE = "AWS_CONFIG_FILE"
+	configBackupSuffix  = ".bak"
+)
+
+// credentialProcessOutput is the document the AWS SDKs expect from a credential_process
+type credentialProcessOutput struct {
+	Version         int    `json:"Version"`
+	AccessKeyId     string `json:"AccessKeyId"`
+	SecretAccessKey string `json:"SecretAccessKey"`
+	SessionToken    string `json:"SessionToken,omitempty"`
+	Expiration      string `json:"Expiration"`
+}
+
+// CredentialProcess prints the STS credentials of role in the format of the AWS credential_process
+func CredentialProcess(engine string, role string, opts CredentialOptions) error {
+	out, err := handleCredentialProcess(engine, role, opts)
+	if err != nil {
+		return err
+	}
+	_, _ = os.Stdout.Write(out)
+	return nil
+}
+
+func handleCredentialProcess(engine string, role string, opts CredentialOptions) ([]byte, error) {
+	creds, err := getCreds(engine, role, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := json.Marshal(credentialProcessOutput{
+		Version:         credentialProcessVersion,
+		AccessKeyId:     creds.SessionId,
+		SecretAccessKey: creds.SessionKey,
+		SessionToken:    creds.SessionToken,
+		Expiration:      creds.Expiration.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal credential process output")
+	}
+	return out, nil
+}
+
+// WriteCredentialProcessProfile writes profile into the AWS config file, which lets the AWS SDKs fetch the STS
+// credentials of role through vaultpal whenever they need them
+func WriteCredentialProcessProfile(engine string, role string, profile string) error {
+	filename, err := resolveConfigFilename()
+	if err != nil {
+		return err
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return errors.Wrap(err, "cannot locate the vaultpal executable")
+	}
+	process := credentialProcessCommand(executable, engine, role)
+
+	opts := utils.FileUpdateOptions{Perm: 0600, BackupSuffix: configBackupSuffix}
+	err = utils.UpdateFile(filename, opts, func(old []byte) ([]byte, error) {
+		return renderCredentialProcessProfile(old, profile, process)
+	})
+	if err != nil {
+		return errors.Wrap(err, "unable to write aws config file")
+	}
+
+	warnShadowingCredentials(profile)
+
+	log.Infof("AWS profile written to: %s \n Use it with `aws --profile %s sts get-caller-identity`", filename, profile)
+	return nil
+}
+
+// credentialProcessCommand renders the credential_process setting calling vaultpal
+func credentialProcessCommand(executable string, engine string, role string) string {
+	if strings.ContainsAny(executable, " \t") {
+		executable = `"` + executable + `"`
+	}
+	return fmt.Sprintf("%s aws credential-process %s --path %s", executable, role, engine)
+}
+
+// renderCredentialProcessProfile sets the credential_process of profile in the content of the AWS config file
+func renderCredentialProcessProfile(old []byte, profile string, process string) ([]byte, error) {
+	return renderConfigProfile(old, profile, func(section *ini.Section) {
+		section.Key("credential_process").SetValue(process)
+	})
+}
+
+// renderProfileSettings sets the region and output of profile in the content of the AWS config file.
+// Empty values keep the current settings, the output defaults to json.
+func renderProfileSettings(old []byte, profile string, region string, output string) ([]byte, error) {
+	return renderConfigProfile(old, profile, func(section *ini.Section) {
+		if region != "" {
+			section.Key("region").SetValue(region)
+		}
+		if output != "" {
+			section.Key("output").SetValue(output)
+		} else if !section.HasKey("output") {
+			section.Key("output").SetValue(defaultOutput)
+		}
+	})
+}
+
+// renderConfigProfile applies set to the section of profile in the content of the AWS config file
+func renderConfigProfile(old []byte, profile string, set func(section *ini.Section)) ([]byte, error) {
+	config := ini.Empty()
+	if len(old) > 0 {
+		var err error
+		config, err = ini.Load(old)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	section, err := config.NewSection(configSectionName(profile))
+	if err != nil {
+		return nil, err
+	}
+	set(section)
+
+	var buf strings.Builder
+	_, err = config.WriteTo(&buf)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(buf.String()), nil
+}
+
+// configSectionName returns the section of profile in the AWS config file, which prefixes all but the default profile
+func configSectionName(profile string) string {
+	if profile == "default" {
+		return profile
+	}
+	return "profile " + profile
+}
+
+// warnShadowingCredentials warns, if the credentials file contains keys for profile, which the AWS SDKs prefer over the credential_process
+func warnShadowingCredentials(profile string) {
+	filename, err := resolveFilename()
+	if err != nil {
+		return
+	}
+	credentials, err := ini.Load(filename)
+	if err != nil {
+		return
+	}
+	if section, err := credentials.GetSection(profile); err == nil && section.HasKey("aws_access_key_id") {
+		log.WithFields(log.Fields{
+			"Profile": profile,
+			"File":    filename,
+		}).Warn("the credentials file contains keys for the profile, which take precedence over the credential_process, remove them")
+	}
+}
+
+// resolveConfigFilename returns the location of the AWS config file
+func resolveConfigFilename() (string, error) {
+	if name := os.Getenv(ENV_AWS_CONFIG_FILE); name != "" {
+		return resolveSymlink(name)
+	}
+
+	var name string
+	var err error
+	if runtime.GOOS == "windows" {
+		name = filepath.Join(os.Getenv("USERPROFILE"), ".aws", "config")
+	} else {
+		name, err = homedir.Expand("~/.aws/config")
+		if err != nil {
+			return "", errors.Wrap(err, "user home directory not found")
+		}
+	}
+
+	name, err = resolveSymlink(name)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to resolve symlink")
+	}
+	return name, nil
+}
@@ -0,0 +1,100 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/ini.v1"
+)
+
+func TestHandleCredentialProcess(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	_ = os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	_ = os.Setenv(api.EnvVaultToken, "1234")
+	m := mockSuccData
+	m.lease_duration = 900
+	vm.ServeMocks[fmt.Sprintf(PATH_READ_STS_CREDS, m.engine, m.role)] = m.mockReadSTSCreds
+
+	out, err := handleCredentialProcess(m.engine, m.role, CredentialOptions{NoCache: true})
+	assert.NoError(t, err)
+
+	got := credentialProcessOutput{}
+	assert.NoError(t, json.Unmarshal(out, &got))
+	assert.Equal(t, 1, got.Version)
+	assert.Equal(t, m.access_key, got.AccessKeyId)
+	assert.Equal(t, m.secret_key, got.SecretAccessKey)
+	assert.Equal(t, m.security_token, got.SessionToken)
+	expiration, err := time.Parse(time.RFC3339, got.Expiration)
+	assert.NoError(t, err)
+	assert.WithinDuration(t, time.Now().Add(15*time.Minute), expiration, time.Minute)
+}
+
+func TestRenderCredentialProcessProfile(t *testing.T) {
+	old := []byte("[default]\nregion = eu-central-1\n\n[profile np]\ncredential_process = stale\n")
+
+	out, err := renderCredentialProcessProfile(old, "np", "vaultpal aws credential-process tsc --path aws")
+	assert.NoError(t, err)
+	got, err := ini.Load(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "eu-central-1", got.Section("default").Key("region").String())
+	assert.Equal(t, "vaultpal aws credential-process tsc --path aws", got.Section("profile np").Key("credential_process").String())
+
+	out, err = renderCredentialProcessProfile(nil, "default", "vaultpal aws credential-process tsc --path aws")
+	assert.NoError(t, err)
+	got, err = ini.Load(out)
+	assert.NoError(t, err)
+	assert.True(t, got.Section("default").HasKey("credential_process"))
+}
+
+func TestRenderProfileSettings(t *testing.T) {
+	old := []byte("[profile np]\ncredential_process = vaultpal\noutput = table\n")
+
+	out, err := renderProfileSettings(old, "np", "eu-west-1", "")
+	assert.NoError(t, err)
+	got, err := ini.Load(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "vaultpal", got.Section("profile np").Key("credential_process").String())
+	assert.Equal(t, "eu-west-1", got.Section("profile np").Key("region").String())
+	assert.Equal(t, "table", got.Section("profile np").Key("output").String(), "the output of the user is kept")
+
+	out, err = renderProfileSettings(nil, "pr", "", "")
+	assert.NoError(t, err)
+	got, err = ini.Load(out)
+	assert.NoError(t, err)
+	assert.False(t, got.Section("profile pr").HasKey("region"))
+	assert.Equal(t, "json", got.Section("profile pr").Key("output").String())
+
+	out, err = renderProfileSettings(old, "np", "", "text")
+	assert.NoError(t, err)
+	got, err = ini.Load(out)
+	assert.NoError(t, err)
+	assert.Equal(t, "text", got.Section("profile np").Key("output").String())
+}
+
+func TestCredentialProcessCommand(t *testing.T) {
+	assert.Equal(t, "/usr/local/bin/vaultpal aws credential-process tsc --path aws",
+		credentialProcessCommand("/usr/local/bin/vaultpal", "aws", "tsc"))
+	assert.Equal(t, `"C:\Program Files\vaultpal.exe" aws credential-process tsc --path aws-np`,
+		credentialProcessCommand(`C:\Program Files\vaultpal.exe`, "aws-np", "tsc"))
+}
+
+func TestWriteCredentialProcessProfile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(ENV_AWS_CONFIG_FILE, filepath.Join(dir, "config"))
+	t.Setenv("HOME", dir)
+
+	err := WriteCredentialProcessProfile("aws", "tsc", "np")
+	assert.NoError(t, err)
+
+	got, err := ini.Load(filepath.Join(dir, "config"))
+	assert.NoError(t, err)
+	assert.Contains(t, got.Section("profile np").Key("credential_process").String(), "aws credential-process tsc --path aws")
+}
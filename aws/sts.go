@@ -0,0 +1,156 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Credential types of the aws secrets engine
+const (
+	CredentialTypeIAMUser         = "iam_user"
+	CredentialTypeAssumedRole     = "assumed_role"
+	CredentialTypeFederationToken = "federation_token"
+	CredentialTypeSessionToken    = "session_token"
+)
+
+var CredentialTypes = []string{CredentialTypeIAMUser, CredentialTypeAssumedRole, CredentialTypeFederationToken, CredentialTypeSessionToken}
+
+// validate verifies the credential type and that the sts parameters are only requested for STS credentials
+func (o CredentialOptions) validate() error {
+	switch o.CredentialType {
+	case "", CredentialTypeAssumedRole, CredentialTypeFederationToken, CredentialTypeSessionToken:
+		return nil
+	case CredentialTypeIAMUser:
+		if o.stsParameters() != nil {
+			return errors.Errorf("role arn, ttl, role session name and mfa code are not supported for credential type %s", CredentialTypeIAMUser)
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown credential type [%s], must be one of %v", o.CredentialType, CredentialTypes)
+	}
+}
+
+// credentialsPath returns the endpoint of the credential type: iam_user credentials are created by creds/,
+// the STS credential types by sts/
+func (o CredentialOptions) credentialsPath(engine string, role string) string {
+	if o.CredentialType == CredentialTypeIAMUser {
+		return fmt.Sprintf("%s/creds/%s", engine, role)
+	}
+	return fmt.Sprintf("%s/sts/%s", engine, role)
+}
+
+// stsParameters returns the parameters of the sts endpoint of the aws secrets engine, nil if none are requested
+func (o CredentialOptions) stsParameters() map[string]interface{} {
+	params := map[string]interface{}{}
+	if o.RoleARN != "" {
+		params["role_arn"] = o.RoleARN
+	}
+	if o.TTL > 0 {
+		params["ttl"] = strconv.Itoa(int(o.TTL.Seconds()))
+	}
+	if o.RoleSessionName != "" {
+		params["role_session_name"] = o.RoleSessionName
+	}
+	if o.MFACode != "" {
+		params["mfa_code"] = o.MFACode
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+// cacheVariant distinguishes the cached credentials of the same role requested with different parameters.
+// The mfa code only authorizes the request and is left out.
+func (o CredentialOptions) cacheVariant() []string {
+	return []string{o.CredentialType, o.RoleARN, o.TTL.String(), o.RoleSessionName}
+}
+
+// validateRole verifies the credential type and the ttl of opts against the settings of role. If the role cannot
+// be read, they are left to vault to verify.
+func validateRole(client *api.Client, engine string, role string, opts CredentialOptions) error {
+	if opts.CredentialType == "" && opts.TTL <= 0 {
+		return nil
+	}
+	settings, err := readRoleSettings(client, engine, role)
+	if err != nil {
+		log.WithError(err).Debug("cannot read settings of role")
+		return nil
+	}
+	if settings == nil {
+		return nil
+	}
+	if opts.CredentialType != "" && len(settings.CredentialTypes) > 0 && !contains(settings.CredentialTypes, opts.CredentialType) {
+		return errors.Errorf("credential type %s is not allowed for role %s, must be one of %v", opts.CredentialType, role, settings.CredentialTypes)
+	}
+	if opts.TTL > 0 && settings.MaxSTSTTL > 0 && opts.TTL > settings.MaxSTSTTL {
+		return errors.Errorf("ttl %s exceeds the maximum %s of role %s", opts.TTL, settings.MaxSTSTTL, role)
+	}
+	return nil
+}
+
+// roleSettings are the settings of a role of the aws secrets engine, which requests are verified against
+type roleSettings struct {
+	CredentialTypes []string
+	// MaxSTSTTL is zero, if the role does not limit the ttl
+	MaxSTSTTL time.Duration
+}
+
+// readRoleSettings reads the credential types and the max_sts_ttl of role, nil if the role does not exist
+func readRoleSettings(client *api.Client, engine string, role string) (*roleSettings, error) {
+	secret, err := client.Logical().Read(engine + "/roles/" + role)
+	if err != nil {
+		return nil, errors.Wrapf(err, "error reading role [%s/roles/%s]", engine, role)
+	}
+	if secret == nil {
+		return nil, nil
+	}
+
+	settings := &roleSettings{}
+	// vault returns the credential types as comma separated string
+	switch types := secret.Data["credential_type"].(type) {
+	case string:
+		for _, t := range strings.Split(types, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				settings.CredentialTypes = append(settings.CredentialTypes, t)
+			}
+		}
+	case []interface{}:
+		for _, t := range types {
+			if t, ok := t.(string); ok {
+				settings.CredentialTypes = append(settings.CredentialTypes, t)
+			}
+		}
+	}
+
+	switch maxTTL := secret.Data["max_sts_ttl"].(type) {
+	case json.Number:
+		seconds, err := maxTTL.Int64()
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid max_sts_ttl of role [%s]", role)
+		}
+		settings.MaxSTSTTL = time.Duration(seconds) * time.Second
+	case string:
+		settings.MaxSTSTTL, err = time.ParseDuration(maxTTL)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid max_sts_ttl of role [%s]", role)
+		}
+	}
+	return settings, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,108 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+const PATH_READ_ROLE = "/v1/%s/roles/%s"
+
+func mockReadRole(maxSTSTTL int) u.ServeMockFunc {
+	return func(t *testing.T, w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		sec := api.Secret{Data: map[string]interface{}{
+			"credential_type": []string{"assumed_role"},
+			"max_sts_ttl":     maxSTSTTL,
+		}}
+		u.WriteJsonResponse(t, sec, w)
+	}
+}
+
+func TestGetCredsSTSParameters(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	_ = os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	_ = os.Setenv(api.EnvVaultToken, "1234")
+	m := mockSuccData
+
+	var method string
+	var body map[string]interface{}
+	vm.ServeMocks[fmt.Sprintf(PATH_READ_STS_CREDS, m.engine, m.role)] = func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		body = nil
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		m.mockReadSTSCreds(t, w, r)
+	}
+	vm.ServeMocks[fmt.Sprintf(PATH_READ_ROLE, m.engine, m.role)] = mockReadRole(4 * 3600)
+
+	_, err := getCreds(m.engine, m.role, CredentialOptions{NoCache: true})
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodGet, method, "without parameters the credentials are read")
+
+	_, err = getCreds(m.engine, m.role, CredentialOptions{
+		NoCache:         true,
+		RoleARN:         "arn:aws:iam::123456789012:role/admin",
+		TTL:             2 * time.Hour,
+		RoleSessionName: "smurf",
+		MFACode:         "123456",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, http.MethodPut, method)
+	assert.Equal(t, map[string]interface{}{
+		"role_arn":          "arn:aws:iam::123456789012:role/admin",
+		"ttl":               "7200",
+		"role_session_name": "smurf",
+		"mfa_code":          "123456",
+	}, body)
+
+	method = ""
+	_, err = getCreds(m.engine, m.role, CredentialOptions{NoCache: true, TTL: 8 * time.Hour})
+	assert.EqualError(t, err, "ttl 8h0m0s exceeds the maximum 4h0m0s of role gopher-vpc-manager")
+	assert.Empty(t, method, "no credentials are requested")
+
+	_, err = getCreds(m.engine, m.role, CredentialOptions{NoCache: true, CredentialType: CredentialTypeFederationToken})
+	assert.EqualError(t, err, "credential type federation_token is not allowed for role gopher-vpc-manager, must be one of [assumed_role]")
+	assert.Empty(t, method, "no credentials are requested")
+
+	_, err = getCreds(m.engine, m.role, CredentialOptions{NoCache: true, CredentialType: CredentialTypeAssumedRole})
+	assert.NoError(t, err)
+}
+
+func TestReadRoleSettings(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	vm.ServeMocks[fmt.Sprintf(PATH_READ_ROLE, "aws", "tsc")] = func(t *testing.T, w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		u.WriteJsonResponse(t, api.Secret{Data: map[string]interface{}{
+			"credential_type": "assumed_role,federation_token",
+			"max_sts_ttl":     "2h",
+		}}, w)
+	}
+
+	client, err := api.NewClient(&api.Config{Address: vm.Server.URL})
+	assert.NoError(t, err)
+	settings, err := readRoleSettings(client, "aws", "tsc")
+	assert.NoError(t, err)
+	assert.Equal(t, &roleSettings{CredentialTypes: []string{CredentialTypeAssumedRole, CredentialTypeFederationToken}, MaxSTSTTL: 2 * time.Hour}, settings)
+}
+
+func TestValidateRoleUnreadableRole(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	_ = os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	_ = os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[fmt.Sprintf(PATH_READ_ROLE, "aws", "tsc")] = (&u.MockErrorData{HTTPStatus: http.StatusForbidden, Errors: &[]string{"permission denied"}}).MockErrorResponse
+
+	client, err := api.NewClient(&api.Config{Address: vm.Server.URL})
+	assert.NoError(t, err)
+	assert.NoError(t, validateRole(client, "aws", "tsc", CredentialOptions{TTL: 8 * time.Hour, CredentialType: CredentialTypeFederationToken}),
+		"vault verifies the request, if the role cannot be read")
+}
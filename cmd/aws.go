@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/dbschenker/vaultpal/aws"
+	"github.com/spf13/cobra"
+)
+
+func newAWSCmd() *cobra.Command {
+	awsCmd := &cobra.Command{
+		Use:   "aws",
+		Short: "Use AWS STS credentials of vault with the AWS SDKs",
+		Long: `Use AWS STS credentials of vault with the AWS SDKs.
+
+Profiles written by vaultpal let the AWS CLI, the SDKs and Terraform fetch fresh credentials on demand.`,
+		Run: runHelp,
+	}
+
+	credentialProcessCmd := &cobra.Command{
+		Use:   "credential-process",
+		Short: "Print AWS STS credentials for the credential_process of an AWS profile",
+		Long: `Get AWS STS credentials from vault for an STS role and print them as JSON in the format of the
+credential_process of the AWS SDKs, see 'vaultpal aws profile'.
+
+Requires 1 argument: [role-name]
+`,
+		Args: cobra.ExactArgs(1),
+		Example: `  # Print credentials of role [tsc-vpc-manager]
+  vaultpal aws credential-process tsc-vpc-manager`,
+		PreRun: logToStderr,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pathF, err := cmd.Flags().GetString("path")
+			if err != nil {
+				return err
+			}
+			opts, err := getAWSCredentialFlags(cmd)
+			if err != nil {
+				return err
+			}
+			return aws.CredentialProcess(pathF, args[0], opts)
+
+		}}
+	setAWSEngineFlag(credentialProcessCmd)
+	setAWSCredentialFlags(credentialProcessCmd)
+	awsCmd.AddCommand(credentialProcessCmd)
+
+	profileCmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Write an AWS profile fetching credentials through vaultpal",
+		Long: `Write a profile into ~/.aws/config (or AWS_CONFIG_FILE), whose credential_process calls
+'vaultpal aws credential-process', so every AWS SDK fetches fresh STS credentials when the old ones expire.
+
+Requires 2 arguments: [role-name] [aws-profile-name]
+`,
+		Args: cobra.ExactArgs(2),
+		Example: `  # Write the profile [np] for role [topic_owner_tsc]
+  vaultpal aws profile topic_owner_tsc np
+  aws --profile np sts get-caller-identity`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pathF, err := cmd.Flags().GetString("path")
+			if err != nil {
+				return err
+			}
+			return aws.WriteCredentialProcessProfile(pathF, args[0], args[1])
+
+		}}
+	setAWSEngineFlag(profileCmd)
+	awsCmd.AddCommand(profileCmd)
+
+	leasesCmd := &cobra.Command{
+		Use:   "leases",
+		Short: "List the leases of iam_user credentials",
+		Long: `List the leases of iam_user credentials issued by vaultpal. Unlike STS credentials, the access keys of
+iam_user credentials stay valid until their lease is revoked or expires, see 'vaultpal aws revoke'.`,
+		Args: cobra.NoArgs,
+		Example: `  # List the leases as a table
+  vaultpal aws leases`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputF, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			return aws.Leases(outputF)
+		}}
+	setOutputFlag(leasesCmd)
+	awsCmd.AddCommand(leasesCmd)
+
+	revokeCmd := &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke iam_user credentials",
+		Long: `Revoke a lease of iam_user credentials by its ID, or all leases of a role recorded for the current vault,
+which deletes the access keys in AWS.
+
+Requires 1 argument: [lease-id|role-name]
+`,
+		Args: cobra.ExactArgs(1),
+		Example: `  # Revoke all iam_user credentials of role [tsc-vpc-manager]
+  vaultpal aws revoke tsc-vpc-manager
+
+  # Revoke a single lease
+  vaultpal aws revoke aws/creds/tsc-vpc-manager/Lp0nNRvLe1jY1mp0HbMeiwLy`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return aws.Revoke(args[0])
+		}}
+	awsCmd.AddCommand(revokeCmd)
+
+	return awsCmd
+}
+
+func setAWSCredentialFlags(cmd *cobra.Command) {
+	cmd.Flags().String("credential-type", "", fmt.Sprintf("Credential type, one of %v (default: credential type of the vault role)", aws.CredentialTypes))
+	cmd.Flags().Bool("no-cache", false, "Neither read nor write the local cache of STS credentials (default: false)")
+	cmd.Flags().Bool("refresh", false, "Fetch new STS credentials from vault, even if the cached ones are still valid (default: false)")
+	cmd.Flags().String("role-arn", "", "ARN of the AWS role to assume, required if the vault role maps to several ARNs")
+	cmd.Flags().Duration("ttl", 0, "TTL of the STS credentials, e.g. 4h, must not exceed the max_sts_ttl of the vault role (default: ttl of the vault role)")
+	cmd.Flags().String("role-session-name", "", "Session name of the assumed role")
+	cmd.Flags().String("mfa-code", "", "Code of the MFA device, if the vault role requires MFA")
+}
+
+func getAWSCredentialFlags(cmd *cobra.Command) (aws.CredentialOptions, error) {
+	credentialTypeF, err := cmd.Flags().GetString("credential-type")
+	if err != nil {
+		return aws.CredentialOptions{}, err
+	}
+	noCacheF, err := cmd.Flags().GetBool("no-cache")
+	if err != nil {
+		return aws.CredentialOptions{}, err
+	}
+	refreshF, err := cmd.Flags().GetBool("refresh")
+	if err != nil {
+		return aws.CredentialOptions{}, err
+	}
+	roleARNF, err := cmd.Flags().GetString("role-arn")
+	if err != nil {
+		return aws.CredentialOptions{}, err
+	}
+	ttlF, err := cmd.Flags().GetDuration("ttl")
+	if err != nil {
+		return aws.CredentialOptions{}, err
+	}
+	roleSessionNameF, err := cmd.Flags().GetString("role-session-name")
+	if err != nil {
+		return aws.CredentialOptions{}, err
+	}
+	mfaCodeF, err := cmd.Flags().GetString("mfa-code")
+	if err != nil {
+		return aws.CredentialOptions{}, err
+	}
+	return aws.CredentialOptions{
+		CredentialType:  credentialTypeF,
+		NoCache:         noCacheF,
+		Refresh:         refreshF,
+		RoleARN:         roleARNF,
+		TTL:             ttlF,
+		RoleSessionName: roleSessionNameF,
+		MFACode:         mfaCodeF,
+	}, nil
+}
+
+func init() {
+	rootCmd.AddCommand(newAWSCmd())
+}
@@ -31,6 +31,7 @@ To configure your bash shell to load completions for each session add to your ba
 		Short: "Completion for zsh",
 		Long:  "Generate completion for zsh",
 		Run: func(cmd *cobra.Command, args []string) {
+			rootCmd.BashCompletionFunction = bashCompletionFunction()
 			runCompletionZsh(os.Stdout)
 		},
 	}
@@ -40,6 +41,8 @@ To configure your bash shell to load completions for each session add to your ba
 		Short: "Completion for bash",
 		Long:  "Generate completion for bash",
 		Run: func(cmd *cobra.Command, args []string) {
+			// the config is read now, so the completion can use the configured cluster registry
+			rootCmd.BashCompletionFunction = bashCompletionFunction()
 			rootCmd.GenBashCompletion(os.Stdout)
 		},
 	}
@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"errors"
+
+	"github.com/dbschenker/vaultpal/kube"
+	"github.com/dbschenker/vaultpal/vault"
+)
+
+// exit codes of vaultpal, documented in the README
+const (
+	exitError              = 1
+	exitCredentialsExpired = 2
+	exitVaultUnreachable   = 3
+	exitClusterNotFound    = 4
+	exitIssueFailed        = 5
+)
+
+// exitCode maps the errors returned by the commands to the exit code of vaultpal
+func exitCode(err error) int {
+	var unreachable *vault.UnreachableError
+	var notFound *kube.ClusterNotFoundError
+	var issue *kube.IssueError
+	switch {
+	case errors.Is(err, kube.ErrCredentialsExpired):
+		return exitCredentialsExpired
+	case errors.As(err, &unreachable):
+		return exitVaultUnreachable
+	case errors.As(err, &notFound):
+		return exitClusterNotFound
+	case errors.As(err, &issue):
+		return exitIssueFailed
+	default:
+		return exitError
+	}
+}
+
+// reportedError is an error, which the command reported on its own already
+type reportedError struct {
+	err error
+}
+
+func (e *reportedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *reportedError) Unwrap() error {
+	return e.err
+}
+
+// silentError reports, if err is already reported by the output of the command
+func silentError(err error) bool {
+	var reported *reportedError
+	return errors.Is(err, kube.ErrCredentialsExpired) || errors.As(err, &reported)
+}
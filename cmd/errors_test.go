@@ -0,0 +1,39 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/dbschenker/vaultpal/kube"
+	"github.com/dbschenker/vaultpal/timer"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		want   int
+		silent bool
+	}{
+		{name: "other", err: errors.New("boom"), want: exitError},
+		{name: "expired credentials", err: fmt.Errorf("status: %w", kube.ErrCredentialsExpired), want: exitCredentialsExpired, silent: true},
+		{name: "unreachable", err: &vault.UnreachableError{Address: "https://vault.invalid", Err: errors.New("dial tcp")}, want: exitVaultUnreachable},
+		{name: "cluster not found", err: &kube.ClusterNotFoundError{Cluster: "jim"}, want: exitClusterNotFound},
+		{name: "issue failed", err: &kube.IssueError{Path: "k8s-pki/issue/master", Err: errors.New("permission denied")}, want: exitIssueFailed},
+		{name: "timer token expired", err: &reportedError{err: timer.ErrTokenExpired}, want: exitError, silent: true},
+		{name: "timer lookup timeout", err: &reportedError{err: &timer.TimeoutError{Address: "https://vault.invalid", Err: context.DeadlineExceeded}}, want: exitError, silent: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, exitCode(tt.err))
+			assert.Equal(t, tt.silent, silentError(tt.err))
+		})
+	}
+
+	assert.EqualError(t, &timer.TimeoutError{Address: "https://vault.invalid", Err: context.DeadlineExceeded},
+		"unset your VAULT_ADDR variable, https://vault.invalid can't be reached")
+}
@@ -3,7 +3,6 @@ package cmd
 import (
 	"errors"
 	"github.com/dbschenker/vaultpal/aws"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"os"
 )
@@ -40,11 +39,13 @@ function to use with flag -a/-alias. See examples.
   vaultpal export awssts -a
   # or direct alias definition with vaultpal
   alias palsts="$(vaultpal export awssts -a)"`,
+		// stdout carries the exports to be evaluated by the shell
+		PreRun: logToStderr,
 		RunE: func(cmd *cobra.Command, args []string) error {
 
 			aliasF, err := cmd.Flags().GetBool("alias")
 			if err != nil {
-				log.Fatalf("cannot read alias flag: %s", err)
+				return err
 			}
 			if aliasF {
 				_, _ = os.Stdout.Write([]byte(bashAWSSTSAlias))
@@ -56,13 +57,18 @@ function to use with flag -a/-alias. See examples.
 			}
 			pathF, err := cmd.Flags().GetString("path")
 			if err != nil {
-				log.Fatalf("cannot read aws engine path: %s", err)
+				return err
 			}
-			return aws.ExportSTSCredentials(pathF, args[0])
+			opts, err := getAWSCredentialFlags(cmd)
+			if err != nil {
+				return err
+			}
+			return aws.ExportSTSCredentials(pathF, args[0], opts)
 
 		}}
 
 	setAWSEngineFlag(awsstsCmd)
+	setAWSCredentialFlags(awsstsCmd)
 	awsstsCmd.Flags().BoolP("alias", "a", false, "Print an alias function to use in bash for awssts command (default: false)")
 	exportCmd.AddCommand(awsstsCmd)
 
@@ -76,11 +82,13 @@ Requires 1 argument: [role-name]
 		Args: cobra.MaximumNArgs(1),
 		Example: `  # Generate AWS console URL for engine (aws) with role [tsc-vpc-manager]
   vaultpal export awsconsole tsc-vpc-manager`,
+		// stdout carries the console url
+		PreRun: logToStderr,
 		RunE: func(cmd *cobra.Command, args []string) error {
 
 			suppressBrowserF, err := cmd.Flags().GetBool("suppress-open")
 			if err != nil {
-				log.Fatalf("cannot read suppress-open flag: %s", err)
+				return err
 			}
 
 			if len(args) != 1 {
@@ -88,12 +96,17 @@ Requires 1 argument: [role-name]
 			}
 			pathF, err := cmd.Flags().GetString("path")
 			if err != nil {
-				log.Fatalf("cannot read aws engine path: %s", err)
+				return err
+			}
+			opts, err := getAWSCredentialFlags(cmd)
+			if err != nil {
+				return err
 			}
-			return aws.GenerateConsoleURL(pathF, suppressBrowserF, args[0])
+			return aws.GenerateConsoleURL(pathF, suppressBrowserF, args[0], opts)
 
 		}}
 	setAWSEngineFlag(awsConsoleCmd)
+	setAWSCredentialFlags(awsConsoleCmd)
 	awsConsoleCmd.Flags().BoolP("suppress-open", "s", false, "Suppress opening URL in default browser (default: false)")
 	exportCmd.AddCommand(awsConsoleCmd)
 
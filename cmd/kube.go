@@ -0,0 +1,445 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/dbschenker/vaultpal/kube"
+	"github.com/dbschenker/vaultpal/utils"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+func newKubeCmd() *cobra.Command {
+	kubeCmd := &cobra.Command{
+		Use:   "kube",
+		Short: "Manage access to kubernetes clusters",
+		Long: `Manage access to kubernetes clusters.
+
+Kube requires a subcommand like credential, e.g.:
+
+vaultpal kube credential int webclaims-dev`,
+		Run: nil,
+	}
+
+	credentialCmd := &cobra.Command{
+		Use:   "credential",
+		Short: "Print a kubernetes exec credential for a cluster",
+		Long: `Print a kubernetes exec credential (client.authentication.k8s.io/v1) created with vault secrets.
+
+The command is meant to be called by kubectl as exec credential plugin, see 'vaultpal write kubeconfig --exec'.
+Issued certificates are cached locally and reissued shortly before they expire.
+
+Requires 2 arguments: [cluster-name] [role-name]
+`,
+		Args: cobra.ExactArgs(2),
+		Example: `  # Print exec credential for cluster [int] with the vault role [webclaims-dev]
+  vaultpal kube credential int webclaims-dev`,
+		PreRun: logToStderr,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clusterRoleBindingF, err := cmd.Flags().GetBool("cluster-role-binding")
+			if err != nil {
+				return err
+			}
+			namespaceF, err := cmd.Flags().GetString("namespace")
+			if err != nil {
+				return err
+			}
+			opts := kube.WriteOptions{
+				ClusterRoleBinding: clusterRoleBindingF,
+				Namespace:          namespaceF,
+			}
+			err = getCredentialFlags(cmd, &opts)
+			if err != nil {
+				return err
+			}
+			return kube.WriteExecCredential(args[0], args[1], opts)
+
+		}}
+	setClusterRoleBindingFlag(credentialCmd)
+	setNamespaceFlag(credentialCmd)
+	setCredentialFlags(credentialCmd)
+	kubeCmd.AddCommand(credentialCmd)
+
+	clustersCmd := &cobra.Command{
+		Use:   "clusters",
+		Short: "List the clusters of the registry",
+		Long: `List the kubernetes clusters defined in the vaultpal cluster registry.
+
+Aliases are resolved to the pki of the cluster they point to.
+`,
+		Args: cobra.NoArgs,
+		Example: `  # List all clusters
+  vaultpal kube clusters
+
+  # List all clusters as json
+  vaultpal kube clusters -o json`,
+		PreRun: logToStderr,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputF, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			return kube.ListClusters(outputF)
+
+		}}
+	setOutputFlag(clustersCmd)
+	kubeCmd.AddCommand(clustersCmd)
+
+	clusterCmd := &cobra.Command{
+		Use:   "cluster",
+		Short: "Inspect a cluster of the registry",
+		Long: `Inspect a kubernetes cluster defined in the vaultpal cluster registry.
+
+Cluster requires a subcommand like describe, e.g.:
+
+vaultpal kube cluster describe int`,
+		Run: nil,
+	}
+
+	describeCmd := &cobra.Command{
+		Use:   "describe",
+		Short: "Describe a cluster of the registry",
+		Long: `Describe a kubernetes cluster defined in the vaultpal cluster registry.
+
+Shows the server, the resolved pki and the pki roles your current token can issue client certificates for.
+
+Requires 1 argument: [cluster-name]
+`,
+		Args: cobra.ExactArgs(1),
+		Example: `  # Describe cluster [int]
+  vaultpal kube cluster describe int`,
+		PreRun: logToStderr,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputF, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			return kube.DescribeCluster(args[0], outputF)
+
+		}}
+	setOutputFlag(describeCmd)
+	clusterCmd.AddCommand(describeCmd)
+	kubeCmd.AddCommand(clusterCmd)
+
+	resolveCmd := &cobra.Command{
+		Use:   "resolve",
+		Short: "Show the cluster an alias points to",
+		Long: `Show the cluster an alias of the vaultpal cluster registry currently points to.
+
+Aliases may point to other aliases, e.g. prod -> prod-blue -> cluster-17, which is useful during blue/green
+migrations of clusters. The full resolution path is shown.
+
+Requires 1 argument: [cluster-name]
+`,
+		Args: cobra.ExactArgs(1),
+		Example: `  # Show the cluster alias [prod] points to
+  vaultpal kube resolve prod`,
+		PreRun: logToStderr,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputF, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			return kube.Resolve(args[0], outputF)
+
+		}}
+	setOutputFlag(resolveCmd)
+	kubeCmd.AddCommand(resolveCmd)
+
+	statusCmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the expiry of the kubeconfig credentials",
+		Long: `Show the client certificates in the vaultpal kubeconfig with cluster, common name, role, namespace and time to expiry.
+
+Optional arguments: [cluster-name...] to restrict the status to these clusters
+
+Exit codes: 0 if all certificates are valid, 2 if any certificate is expired or missing, 1 on other errors.
+`,
+		Example: `  # Show status of all credentials
+  vaultpal kube status
+
+  # Rewrite the kubeconfig for cluster [int], if its certificate expired
+  vaultpal kube status int -o json >/dev/null || vaultpal write kubeconfig int webclaims-dev`,
+		PreRun: logToStderr,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputF, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			return kube.Status(outputF, args...)
+
+		}}
+	setOutputFlag(statusCmd)
+	kubeCmd.AddCommand(statusCmd)
+
+	refreshCmd := &cobra.Command{
+		Use:   "refresh",
+		Short: "Reissue the credentials of all kubeconfig contexts",
+		Long: `Reissue the credentials of all contexts in the vaultpal kubeconfig.
+
+Cluster and role of each context are taken from the metadata recorded by 'vaultpal write kubeconfig'.
+Contexts are reissued concurrently, a failing context does not abort the others.
+Namespaces and the current context are kept as they are.
+`,
+		Args: cobra.NoArgs,
+		Example: `  # Reissue all contexts
+  vaultpal kube refresh
+
+  # Reissue all contexts, at most 8 at a time
+  vaultpal kube refresh --parallel 8`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parallelF, err := cmd.Flags().GetInt("parallel")
+			if err != nil {
+				return err
+			}
+			return kube.Refresh(parallelF)
+
+		}}
+	refreshCmd.Flags().IntP("parallel", "p", kube.DefaultRefreshParallelism, "Number of contexts reissued concurrently")
+	kubeCmd.AddCommand(refreshCmd)
+
+	pruneCmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove stale entries from the kubeconfig",
+		Long: `Remove stale entries from the vaultpal kubeconfig.
+
+Removes contexts with expired client certificates, contexts of clusters which no longer exist in the cluster registry,
+and users and clusters no context references.
+`,
+		Args: cobra.NoArgs,
+		Example: `  # List the entries, which would be removed
+  vaultpal kube prune --dry-run
+
+  # Remove stale entries
+  vaultpal kube prune`,
+		PreRun: logToStderr,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			outputF, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			dryRunF, err := cmd.Flags().GetBool("dry-run")
+			if err != nil {
+				return err
+			}
+			return kube.Prune(outputF, kube.PruneOptions{DryRun: dryRunF})
+
+		}}
+	setOutputFlag(pruneCmd)
+	pruneCmd.Flags().Bool("dry-run", false, "List the entries, which would be removed, without changing the kubeconfig")
+	kubeCmd.AddCommand(pruneCmd)
+
+	removeCmd := &cobra.Command{
+		Use:   "remove",
+		Short: "Remove the contexts written by vaultpal from a kubeconfig",
+		Long: `Remove the contexts written by vaultpal from a kubeconfig, together with their users and clusters.
+Entries not written by vaultpal are kept.
+
+Optional arguments: [cluster-name...] to only remove the contexts of these clusters
+`,
+		Example: `  # Remove all vaultpal contexts merged into ~/.kube/config
+  vaultpal kube remove --target kubeconfig
+
+  # Remove the context of cluster [int] from the vaultpal kubeconfig
+  vaultpal kube remove int`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetF, err := cmd.Flags().GetString("target")
+			if err != nil {
+				return err
+			}
+			return kube.Remove(targetF, args...)
+
+		}}
+	setTargetFlag(removeCmd)
+	kubeCmd.AddCommand(removeCmd)
+
+	revokeCmd := &cobra.Command{
+		Use:   "revoke",
+		Short: "Revoke the client certificates of a cluster and remove its contexts",
+		Long: `Revoke the client certificates vaultpal issued for the contexts of a cluster at the pki of the cluster,
+including the certificates cached for the exec credential plugin, and remove the contexts from the kubeconfig.
+
+Requires 1 argument: [cluster-name]
+`,
+		Args: cobra.ExactArgs(1),
+		Example: `  # Revoke the client certificate of cluster [int] before leaving the workstation
+  vaultpal kube revoke int
+
+  # Revoke the client certificate of the context vaultpal-int merged into ~/.kube/config
+  vaultpal kube revoke int --target kubeconfig`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetF, err := cmd.Flags().GetString("target")
+			if err != nil {
+				return err
+			}
+			return kube.Revoke(targetF, args[0])
+
+		}}
+	setTargetFlag(revokeCmd)
+	kubeCmd.AddCommand(revokeCmd)
+
+	checkCmd := &cobra.Command{
+		Use:   "check",
+		Short: "Check connectivity and permissions of the context of a cluster",
+		Long: `Check the context vaultpal wrote for a cluster: call /version of the api server with the credentials of the
+context and report with SelfSubjectAccessReviews and a SelfSubjectRulesReview, what the user can do in the namespace.
+
+Requires 1 argument: [cluster-name]
+`,
+		Args: cobra.ExactArgs(1),
+		Example: `  # Check the context of cluster [int]
+  vaultpal kube check int
+
+  # Check the context vaultpal-int merged into ~/.kube/config
+  vaultpal kube check int --target kubeconfig -o json`,
+		PreRun: logToStderr,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetF, err := cmd.Flags().GetString("target")
+			if err != nil {
+				return err
+			}
+			outputF, err := cmd.Flags().GetString("output")
+			if err != nil {
+				return err
+			}
+			return kube.Check(targetF, args[0], outputF)
+
+		}}
+	setTargetFlag(checkCmd)
+	setOutputFlag(checkCmd)
+	kubeCmd.AddCommand(checkCmd)
+
+	return kubeCmd
+}
+
+func setOutputFlag(cmd *cobra.Command) *string {
+	return cmd.Flags().StringP("output", "o", utils.OutputTable, fmt.Sprintf("Output format, one of %v", utils.OutputFormats))
+}
+
+func setTargetFlag(cmd *cobra.Command) *string {
+	return cmd.Flags().String("target", kube.TargetVaultpal, fmt.Sprintf("Kubeconfig to write, one of %v", kube.Targets))
+}
+
+func setSplitFlags(cmd *cobra.Command) {
+	cmd.Flags().String("split", "", fmt.Sprintf("Write the context into a kubeconfig file of its own under ~/.vaultpal/kube and print the KUBECONFIG export, one of %v", kube.Splits))
+	cmd.Flags().Bool("no-switch", false, "Keep the current context of the kubeconfig (default: false)")
+}
+
+func getSplitFlags(cmd *cobra.Command, opts *kube.WriteOptions) error {
+	splitF, err := cmd.Flags().GetString("split")
+	if err != nil {
+		return err
+	}
+	noSwitchF, err := cmd.Flags().GetBool("no-switch")
+	if err != nil {
+		return err
+	}
+	opts.Split = splitF
+	opts.NoSwitch = noSwitchF
+	if splitF != "" {
+		// stdout carries the KUBECONFIG export to be evaluated by the shell
+		logToStderr(cmd, nil)
+	}
+	return nil
+}
+
+// setWriteFlags adds the flags shared by the commands writing a kubeconfig
+func setWriteFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("exec", false, "Use vaultpal as exec credential plugin instead of writing a static client certificate (default: false)")
+	cmd.Flags().Bool("prune", false, "Remove expired, unregistered and unreferenced entries from the kubeconfig, see 'vaultpal kube prune' (default: false)")
+	cmd.Flags().Bool("check", false, "Check connectivity and permissions of the written context, see 'vaultpal kube check' (default: false)")
+	cmd.Flags().Bool("revoke", false, "Revoke the client certificate of the replaced context (default: false)")
+	setTargetFlag(cmd)
+	setClusterRoleBindingFlag(cmd)
+	setNamespaceFlag(cmd)
+	setCredentialFlags(cmd)
+	setSplitFlags(cmd)
+}
+
+func getWriteFlags(cmd *cobra.Command) (kube.WriteOptions, error) {
+	opts := kube.WriteOptions{}
+	var err error
+	opts.Exec, err = cmd.Flags().GetBool("exec")
+	if err != nil {
+		return opts, err
+	}
+	opts.Prune, err = cmd.Flags().GetBool("prune")
+	if err != nil {
+		return opts, err
+	}
+	opts.Check, err = cmd.Flags().GetBool("check")
+	if err != nil {
+		return opts, err
+	}
+	opts.Revoke, err = cmd.Flags().GetBool("revoke")
+	if err != nil {
+		return opts, err
+	}
+	opts.Target, err = cmd.Flags().GetString("target")
+	if err != nil {
+		return opts, err
+	}
+	opts.ClusterRoleBinding, err = cmd.Flags().GetBool("cluster-role-binding")
+	if err != nil {
+		return opts, err
+	}
+	opts.Namespace, err = cmd.Flags().GetString("namespace")
+	if err != nil {
+		return opts, err
+	}
+	err = getCredentialFlags(cmd, &opts)
+	if err != nil {
+		return opts, err
+	}
+	err = getSplitFlags(cmd, &opts)
+	return opts, err
+}
+
+func setClusterRoleBindingFlag(cmd *cobra.Command) *bool {
+	return cmd.Flags().Bool("cluster-role-binding", false, "Bind the role cluster wide, if the cluster issues service account tokens (default: false)")
+}
+
+func setNamespaceFlag(cmd *cobra.Command) *string {
+	return cmd.Flags().StringP("namespace", "n", "", "Namespace of the context, derived from the role by the namespace rules if empty")
+}
+
+// setCredentialFlags adds the flags for the ttl, key and alternative names of issued credentials
+func setCredentialFlags(cmd *cobra.Command) {
+	cmd.Flags().Duration("ttl", 0, "TTL of the issued credentials, e.g. 8h (default: ttl of the cluster in the registry or 1h)")
+	cmd.Flags().String("key-type", "", fmt.Sprintf("Key type of the client certificate generated locally like with --sign, one of %v (default: key type of the pki role, ec with --sign)", kube.KeyTypes))
+	cmd.Flags().Int("key-bits", 0, "Key bits of the client certificate, requires --key-type")
+	cmd.Flags().StringSlice("alt-names", nil, "Additional subject alternative names of the client certificate")
+	cmd.Flags().Bool("sign", false, "Generate the client key locally and let vault sign it, so the key never leaves the workstation (default: false)")
+}
+
+func getCredentialFlags(cmd *cobra.Command, opts *kube.WriteOptions) error {
+	var err error
+	opts.TTL, err = cmd.Flags().GetDuration("ttl")
+	if err != nil {
+		return err
+	}
+	opts.KeyType, err = cmd.Flags().GetString("key-type")
+	if err != nil {
+		return err
+	}
+	opts.KeyBits, err = cmd.Flags().GetInt("key-bits")
+	if err != nil {
+		return err
+	}
+	opts.AltNames, err = cmd.Flags().GetStringSlice("alt-names")
+	if err != nil {
+		return err
+	}
+	opts.Sign, err = cmd.Flags().GetBool("sign")
+	return err
+}
+
+// logToStderr keeps stdout clean for the output of the command
+func logToStderr(cmd *cobra.Command, args []string) {
+	log.SetOutput(os.Stderr)
+}
+
+func init() {
+	rootCmd.AddCommand(newKubeCmd())
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dbschenker/vaultpal/kube"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// kubectlPluginName is the name of the binary, that makes kubectl offer vaultpal as 'kubectl vaultpal'
+const kubectlPluginName = "kubectl-vaultpal"
+
+// isKubectlPlugin reports, if the binary was invoked as kubectl plugin, e.g. through a symlink kubectl-vaultpal
+func isKubectlPlugin(arg0 string) bool {
+	return strings.TrimSuffix(filepath.Base(arg0), ".exe") == kubectlPluginName
+}
+
+func newPluginCmd() *cobra.Command {
+	pluginCmd := &cobra.Command{
+		Use:   kubectlPluginName,
+		Short: "Manage vaultpal kubeconfig contexts from kubectl",
+		Annotations: map[string]string{
+			cobra.CommandDisplayNameAnnotation: "kubectl vaultpal",
+		},
+		Long: `Manage the kubeconfig contexts written by vaultpal from kubectl.
+
+Link or copy the vaultpal binary as kubectl-vaultpal into your PATH to use it as kubectl plugin.`,
+		Run: runHelp,
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return setUpLogs(os.Stderr, v)
+		},
+	}
+	pluginCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.vaultpal.yaml)")
+	pluginCmd.PersistentFlags().StringVarP(&v, "verbosity", "v", logrus.InfoLevel.String(), "Log level (debug, info, warn, error, fatal, panic")
+
+	loginCmd := &cobra.Command{
+		Use:   "login",
+		Short: "Write the context of a cluster and switch to it",
+		Long: `Issue credentials for a cluster with a vault role, write its context and switch to it,
+like 'vaultpal write kubeconfig'.
+
+Requires 2 arguments: [cluster-name] [role-name]
+`,
+		Args: cobra.ExactArgs(2),
+		Example: `  # Login to cluster [int] with the vault role [webclaims-dev]
+  kubectl vaultpal login int webclaims-dev`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			opts, err := getWriteFlags(cmd)
+			if err != nil {
+				return err
+			}
+			return kube.WriteKubeconfig(args[0], args[1], opts)
+
+		}}
+	setWriteFlags(loginCmd)
+	pluginCmd.AddCommand(loginCmd)
+
+	ctxCmd := &cobra.Command{
+		Use:   "ctx",
+		Short: "Switch to the context of a cluster",
+		Long: `Make the context vaultpal wrote for a cluster the current context.
+The credentials are only reissued, if they are expired.
+
+Requires 1 argument: [cluster-name]
+`,
+		Args: cobra.ExactArgs(1),
+		Example: `  # Switch to the context of cluster [int]
+  kubectl vaultpal ctx int`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetF, err := cmd.Flags().GetString("target")
+			if err != nil {
+				return err
+			}
+			return kube.UseContext(targetF, args[0])
+
+		}}
+	setTargetFlag(ctxCmd)
+	pluginCmd.AddCommand(ctxCmd)
+
+	nsCmd := &cobra.Command{
+		Use:   "ns",
+		Short: "Switch the namespace of the current context",
+		Long: `Change the namespace of the current context.
+
+Requires 1 argument: [namespace]
+`,
+		Args: cobra.ExactArgs(1),
+		Example: `  # Switch to the namespace [webclaims-ci]
+  kubectl vaultpal ns webclaims-ci`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			targetF, err := cmd.Flags().GetString("target")
+			if err != nil {
+				return err
+			}
+			return kube.SetNamespace(targetF, args[0])
+
+		}}
+	setTargetFlag(nsCmd)
+	pluginCmd.AddCommand(nsCmd)
+
+	return pluginCmd
+}
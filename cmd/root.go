@@ -2,16 +2,19 @@ package cmd
 
 import (
 	"fmt"
+	"github.com/dbschenker/vaultpal/config"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"io"
 	"os"
+	"strings"
 	"time"
 
 	homedir "github.com/mitchellh/go-homedir"
 	"github.com/spf13/viper"
 )
 
+// custom_completion is a template, the registry path of the kube cluster definitions is added by bashCompletionFunction
 const custom_completion = `__vaultpal_parse_list() {
     local vaultpal_output out
     if vaultpal_output=$(vault list $1 2>/dev/null); then
@@ -23,7 +26,8 @@ const custom_completion = `__vaultpal_parse_list() {
 __vaultpal_list_cluster_pki_roles() {
     local vault_pki clustername
     clustername=$1
-    if vault_pki=$(vault kv get -field=pki kv/vaultpal/k8s/clusters/"${clustername}" 2>/dev/null); then
+    if vault_pki=$(vault kv get -field=pki %[1]s/"${clustername}" 2>/dev/null) || \
+        vault_pki=$(vault kv get -field=kubernetes %[1]s/"${clustername}" 2>/dev/null); then
         if vaultpal_output=$(vault list "${vault_pki}"/roles 2>/dev/null); then
           local vaultout=(${vaultpal_output})
           COMPREPLY=( $( compgen -W "${vaultout[*]:2}" -- "$cur" ) )
@@ -33,7 +37,7 @@ __vaultpal_list_cluster_pki_roles() {
 
 __vaultpal_list_kubeconfig() {
     if [[ ${#nouns[@]} -eq 0 ]]; then
-        __vaultpal_list_items "kv/metadata/vaultpal/k8s/clusters"
+        __vaultpal_list_kv_items "%[1]s"
     elif [[ ${#nouns[@]} -eq 1 ]]; then
         __vaultpal_list_cluster_pki_roles ${nouns[0]}
     else
@@ -48,6 +52,14 @@ __vaultpal_list_items() {
     fi
 }
 
+__vaultpal_list_kv_items() {
+    local vaultpal_output
+    if vaultpal_output=$(vault kv list $1 2>/dev/null); then
+        local vaultout=(${vaultpal_output})
+        COMPREPLY=( $( compgen -W "${vaultout[*]:2}" -- "$cur" ) )
+    fi
+}
+
 __vaultpal_custom_func() {
     case ${last_command} in
         vaultpal_export_awssts)
@@ -64,10 +76,17 @@ __vaultpal_custom_func() {
             __vaultpal_list_items "auth/token/roles"
             return
             ;;
-        vaultpal_write_kubeconfig)
+        vaultpal_write_kubeconfig|vaultpal_kube_credential)
             __vaultpal_list_kubeconfig
             return
             ;;
+        vaultpal_kube_cluster_describe)
+            if [[ ${#nouns[@]} -ge 1 ]]; then
+              return
+            fi
+            __vaultpal_list_kv_items "%[1]s"
+            return
+            ;;
         *)
             ;;
     esac
@@ -89,15 +108,22 @@ var rootCmd = &cobra.Command{
 	Short:                  "vault~Pal 👍 will assist you",
 	Long:                   `vault~Pal 👍 will help you using vault in your daily work.`,
 	Run:                    runHelp,
-	BashCompletionFunction: custom_completion,
+	BashCompletionFunction: bashCompletionFunction(),
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
+// When invoked as kubectl plugin, the kubectl vaultpal commands are executed instead.
 func Execute() {
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	cmd := rootCmd
+	if isKubectlPlugin(os.Args[0]) {
+		cmd = newPluginCmd()
+	}
+	if err := cmd.Execute(); err != nil {
+		if !silentError(err) {
+			fmt.Println(err)
+		}
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -127,6 +153,11 @@ func init() {
 
 }
 
+// bashCompletionFunction renders the custom completion for the configured cluster registry
+func bashCompletionFunction() string {
+	return fmt.Sprintf(custom_completion, config.GetKubeRegistry().Path())
+}
+
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	if cfgFile != "" {
@@ -145,11 +176,15 @@ func initConfig() {
 		viper.SetConfigName(".vaultpal")
 	}
 
-	viper.AutomaticEnv() // read in environment variables that match
+	// read in environment variables that match, e.g. VAULTPAL_KUBE_REGISTRY_MOUNT for kube.registry.mount
+	viper.SetEnvPrefix("vaultpal")
+	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.AutomaticEnv()
 
 	// If a config file is found, read it in.
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		// stdout is reserved for command output like exports or exec credentials
+		fmt.Fprintln(os.Stderr, "Using config file:", viper.ConfigFileUsed())
 	}
 }
 
@@ -1,7 +1,12 @@
 package cmd
 
 import (
+	"errors"
+	"fmt"
+	"os"
+
 	"github.com/dbschenker/vaultpal/timer"
+	"github.com/dbschenker/vaultpal/vault"
 	"github.com/spf13/cobra"
 )
 
@@ -18,8 +23,26 @@ func newTimerCmd() *cobra.Command {
 		Long: `Display the remaining TTL of your vault token.
 Put it in your shell prompt to indicate, what vault instance you are currently using 
 and how long your current token is valid`,
-		Run: func(cmd *cobra.Command, args []string) {
-			timer.Timer(bash, query, clear)
+		// the shell prompt must not show usage or errors
+		SilenceUsage:  true,
+		SilenceErrors: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			err := timer.Timer(bash, query, clear)
+			var timeout *timer.TimeoutError
+			var clientErr *vault.ClientError
+			switch {
+			case err == nil:
+				return nil
+			case errors.Is(err, timer.ErrTokenExpired):
+				return &reportedError{err: err}
+			case errors.As(err, &timeout), errors.As(err, &clientErr):
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				return &reportedError{err: err}
+			default:
+				// the shell prompt must not break without token or network
+				_, _ = fmt.Fprintln(os.Stderr, err)
+				return nil
+			}
 		},
 	}
 
@@ -29,11 +29,40 @@ Requires 2 arguments: [cluster-name] [role-name]
 `,
 		Args: cobra.ExactArgs(2),
 		Example: `  # Write kubeconfig for cluster [int] with the vault role [webclaims-dev] (webclaims topic admin in the namespace webclaims-dev)
-  vaultpal write kubeconfig int webclaims-dev`,
+  vaultpal write kubeconfig int webclaims-dev
+
+  # Write kubeconfig for the role [webclaims-ci] with the namespace [webclaims]
+  vaultpal write kubeconfig int webclaims-ci --namespace webclaims
+
+  # Write kubeconfig, that lets kubectl renew the client certificate with vaultpal on demand
+  vaultpal write kubeconfig int webclaims-dev --exec
+
+  # Write kubeconfig and remove stale entries of other clusters
+  vaultpal write kubeconfig int webclaims-dev --prune
+
+  # Write kubeconfig with a client certificate valid for 8 hours, if the registry allows it
+  vaultpal write kubeconfig int webclaims-dev --ttl 8h
+
+  # Write kubeconfig and revoke the client certificate written before
+  vaultpal write kubeconfig int webclaims-dev --revoke
+
+  # Write the context into ~/.vaultpal/kube/int_webclaims-dev.yaml and enable it in the current shell only
+  eval "$(vaultpal write kubeconfig int webclaims-dev --split role)"
+
+  # Write kubeconfig, but keep the current context
+  vaultpal write kubeconfig int webclaims-dev --no-switch
+
+  # Merge the context vaultpal-int into ~/.kube/config, or the first writable file of KUBECONFIG
+  vaultpal write kubeconfig int webclaims-dev --target kubeconfig`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return kube.WriteKubeconfig(args[0], args[1])
+			opts, err := getWriteFlags(cmd)
+			if err != nil {
+				return err
+			}
+			return kube.WriteKubeconfig(args[0], args[1], opts)
 
 		}}
+	setWriteFlags(kubeconfigCmd)
 	writeCmd.AddCommand(kubeconfigCmd)
 
 	awscredsCmd := &cobra.Command{
@@ -45,11 +74,29 @@ Requires 2 arguments: [role-name] [aws-profile-name]
 `,
 		Args: cobra.ExactArgs(2),
 		Example: `  # Write awscreds for role [topic_owner_tsc] with profile [np]
-  vaultpal write awscreds topic_owner_tsc np`,
+  vaultpal write awscreds topic_owner_tsc np
+
+  # Write awscreds of the secret engine [aws-np] with region [eu-west-1]
+  vaultpal write awscreds topic_owner_tsc np --path aws-np --region eu-west-1`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return aws.WriteAWSCreds(args[0], args[1])
+			pathF, err := cmd.Flags().GetString("path")
+			if err != nil {
+				return err
+			}
+			regionF, err := cmd.Flags().GetString("region")
+			if err != nil {
+				return err
+			}
+			opts, err := getAWSCredentialFlags(cmd)
+			if err != nil {
+				return err
+			}
+			return aws.WriteAWSCreds(pathF, args[0], args[1], regionF, opts)
 
 		}}
+	awscredsCmd.Flags().String("region", "", "Region of the AWS profile (default: AWS_REGION or aws.region of the vaultpal config)")
+	setAWSEngineFlag(awscredsCmd)
+	setAWSCredentialFlags(awscredsCmd)
 	writeCmd.AddCommand(awscredsCmd)
 
 	return writeCmd
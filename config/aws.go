@@ -0,0 +1,19 @@
+package config
+
+import "github.com/spf13/viper"
+
+// Settings of the AWS profiles written by vaultpal, e.g. aws.region can also be set by VAULTPAL_AWS_REGION
+const (
+	KeyAWSRegion = "aws.region"
+	KeyAWSOutput = "aws.output"
+)
+
+// GetAWSRegion returns the region of AWS profiles configured in the local vaultpal config, empty if unset
+func GetAWSRegion() string {
+	return viper.GetString(KeyAWSRegion)
+}
+
+// GetAWSOutput returns the output format of AWS profiles configured in the local vaultpal config, empty if unset
+func GetAWSOutput() string {
+	return viper.GetString(KeyAWSOutput)
+}
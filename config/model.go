@@ -2,11 +2,66 @@ package config
 
 import "time"
 
+const (
+	// KubeAuthCertificate authenticates with client certificates issued by a vault pki
+	KubeAuthCertificate = "certificate"
+	// KubeAuthToken authenticates with service account tokens issued by a vault kubernetes secrets engine
+	KubeAuthToken = "token"
+
+	// KubeSchemaVersion is the latest version of the cluster registry schema understood by vaultpal
+	KubeSchemaVersion = 2
+)
+
 type KubeCluster struct {
-	Server string `json: "server"`
-	Name   string `json: "name"`
-	PKI    string `json: "pki"`
-	Alias  string `json: "alias",omitempty`
+	// SchemaVersion of the registry entry, entries without version are version 1
+	SchemaVersion int    `json:"schema_version,omitempty" mapstructure:"schema_version"`
+	Server        string `json:"server"`
+	Name          string `json:"name"`
+	PKI           string `json:"pki"`
+	Alias         string `json:"alias,omitempty"`
+	// Auth is one of KubeAuthCertificate (default) or KubeAuthToken
+	Auth string `json:"auth,omitempty"`
+	// Kubernetes is the mount of the kubernetes secrets engine, if Auth is KubeAuthToken
+	Kubernetes string `json:"kubernetes,omitempty"`
+	// CA is the PEM encoded certificate authority of the api server, if it is not issued by the PKI
+	CA string `json:"ca,omitempty"`
+	// ProxyURL is the proxy kubectl connects to the api server with, e.g. socks5://localhost:1080 of a bastion
+	ProxyURL string `json:"proxy-url,omitempty" mapstructure:"proxy-url"`
+	// TLSServerName is the server name verified against the api server certificate, e.g. behind an ingress
+	TLSServerName string `json:"tls-server-name,omitempty" mapstructure:"tls-server-name"`
+	// Labels are free-form attributes of the cluster like environment or team
+	Labels map[string]string `json:"labels,omitempty"`
+	// Namespaces are rules deriving the namespace of the kubeconfig context from the role
+	Namespaces []NamespaceRule `json:"namespaces,omitempty"`
+	// Credentials are defaults and limits of the credentials issued for the cluster
+	Credentials KubeCredentials `json:"credentials,omitempty"`
+}
+
+// KubeCredentials are defaults and limits of the client certificates or service account tokens issued for a cluster.
+// TTLs are durations like "8h" or seconds.
+type KubeCredentials struct {
+	TTL     string `json:"ttl,omitempty" mapstructure:"ttl"`
+	MaxTTL  string `json:"max_ttl,omitempty" mapstructure:"max_ttl"`
+	KeyType string `json:"key_type,omitempty" mapstructure:"key_type"`
+	KeyBits int    `json:"key_bits,omitempty" mapstructure:"key_bits"`
+	// Sign generates the client key locally and lets vault sign it, instead of issuing the key by vault
+	Sign bool `json:"sign,omitempty" mapstructure:"sign"`
+}
+
+// NamespaceRule derives a namespace from a role. Either Suffix is stripped from the role,
+// or the role must match Regex and the namespace is expanded from the Namespace template, e.g. "$1" or "${topic}".
+type NamespaceRule struct {
+	Suffix    string `json:"suffix,omitempty" mapstructure:"suffix"`
+	Regex     string `json:"regex,omitempty" mapstructure:"regex"`
+	Namespace string `json:"namespace,omitempty" mapstructure:"namespace"`
+}
+
+// AuthMode returns Auth, defaulting to KubeAuthCertificate
+func (k KubeCluster) AuthMode() string {
+	if k.Auth == "" {
+		return KubeAuthCertificate
+	}
+	return k.Auth
 }
 
 // AWSCredentials represents the set of attributes used to authenticate to AWS with a short lived session
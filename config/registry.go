@@ -0,0 +1,58 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// Settings of the vaultpal config file. Each of them can also be set by environment variable,
+// e.g. kube.registry.mount by VAULTPAL_KUBE_REGISTRY_MOUNT
+const (
+	KeyKubeRegistryMount     = "kube.registry.mount"
+	KeyKubeRegistryPrefix    = "kube.registry.prefix"
+	KeyKubeRegistryKVVersion = "kube.registry.kv_version"
+	KeyKubeNamespaces        = "kube.namespaces"
+
+	DefaultKubeRegistryMount  = "kv"
+	DefaultKubeRegistryPrefix = "vaultbro/k8s/clusters"
+)
+
+// KubeRegistry is the location of the KubeCluster definitions in a vault kv secret engine
+type KubeRegistry struct {
+	Mount  string
+	Prefix string
+	// KVVersion of the kv secret engine, 0 if it has to be detected
+	KVVersion int
+}
+
+// Path returns the logical path of the registry, as used by `vault kv` commands
+func (r KubeRegistry) Path() string {
+	return r.Mount + "/" + r.Prefix
+}
+
+func GetKubeRegistry() KubeRegistry {
+	registry := KubeRegistry{
+		Mount:     strings.Trim(viper.GetString(KeyKubeRegistryMount), "/"),
+		Prefix:    strings.Trim(viper.GetString(KeyKubeRegistryPrefix), "/"),
+		KVVersion: viper.GetInt(KeyKubeRegistryKVVersion),
+	}
+	if registry.Mount == "" {
+		registry.Mount = DefaultKubeRegistryMount
+	}
+	if registry.Prefix == "" {
+		registry.Prefix = DefaultKubeRegistryPrefix
+	}
+	return registry
+}
+
+// GetKubeNamespaceRules returns the rules deriving namespaces from roles, which are defined in the local vaultpal config
+func GetKubeNamespaceRules() ([]NamespaceRule, error) {
+	rules := []NamespaceRule{}
+	err := viper.UnmarshalKey(KeyKubeNamespaces, &rules)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid namespace rules [%s] in vaultpal config", KeyKubeNamespaces)
+	}
+	return rules, nil
+}
@@ -0,0 +1,83 @@
+package kube
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/dbschenker/vaultpal/config"
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/pkg/errors"
+)
+
+// maxAliasDepth is the maximum number of aliases followed to the cluster issuing the credentials
+const maxAliasDepth = 5
+
+// resolveAliasChain follows the aliases starting at cluster, e.g. prod -> prod-blue -> cluster-17.
+// It returns the definitions along the chain, the last one is the cluster issuing the credentials, and the
+// names of the registry entries read.
+func resolveAliasChain(reg *registry, cluster string) ([]config.KubeCluster, []string, error) {
+	chain := []config.KubeCluster{}
+	path := []string{}
+	seen := map[string]bool{}
+
+	name := cluster
+	for {
+		if seen[name] {
+			return chain, path, errors.Errorf("alias cycle detected: %s", formatAliasPath(append(path, name)))
+		}
+		if len(path) > maxAliasDepth {
+			return chain, path, errors.Errorf("alias chain exceeds the maximum depth of %d: %s", maxAliasDepth, formatAliasPath(path))
+		}
+		seen[name] = true
+		path = append(path, name)
+
+		cf, err := getPalKubeConfig(reg, name)
+		if err != nil {
+			return chain, path, err
+		}
+		err = verifyPalKubeConfig(cf)
+		if err != nil {
+			return chain, path, err
+		}
+		chain = append(chain, cf)
+
+		if cf.Alias == "" {
+			return chain, path, nil
+		}
+		name = cf.Alias
+	}
+}
+
+func formatAliasPath(path []string) string {
+	return strings.Join(path, " -> ")
+}
+
+// Resolve prints the alias chain of cluster and the cluster it currently points to
+func Resolve(cluster string, format string) error {
+	client, err := vault.NewClient()
+	if err != nil {
+		return errors.Wrap(err, "error creating vault api client")
+	}
+
+	reg, err := newRegistry(client)
+	if err != nil {
+		return err
+	}
+
+	info, err := clusterInfo(reg, cluster)
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteOutput(os.Stdout, format, info, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "Name:\t%s\n", info.Name)
+		_, _ = fmt.Fprintf(w, "Server:\t%s\n", info.Server)
+		_, _ = fmt.Fprintf(w, "Path:\t%s\n", formatAliasPath(info.Path))
+		_, _ = fmt.Fprintf(w, "Target:\t%s\n", info.Target)
+		_, _ = fmt.Fprintf(w, "Auth:\t%s\n", info.Auth)
+		_, _ = fmt.Fprintf(w, "Mount:\t%s\n", info.mount())
+	})
+}
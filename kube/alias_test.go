@@ -0,0 +1,117 @@
+package kube
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveAliasChain(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+
+	alias := func(name string, target string) u.ServeMockFunc {
+		return (&mockData{clusterName: name, serverURL: name + ".tsc.sh", aliasName: target}).mockReadPalConfig
+	}
+
+	tests := []struct {
+		name     string
+		cluster  string
+		mocks    map[string]u.ServeMockFunc
+		wantPath []string
+		wantErr  string
+	}{
+		{
+			name:    "alias of an alias",
+			cluster: "prod",
+			mocks: map[string]u.ServeMockFunc{
+				"prod":       alias("prod", "prod-blue"),
+				"prod-blue":  alias("prod-blue", "cluster-17"),
+				"cluster-17": (&mockData{clusterName: "cluster-17", pkiName: "k8s-pki-17", serverURL: "cluster-17.tsc.sh"}).mockReadPalConfig,
+			},
+			wantPath: []string{"prod", "prod-blue", "cluster-17"},
+		},
+		{
+			name:    "cycle",
+			cluster: "prod",
+			mocks: map[string]u.ServeMockFunc{
+				"prod":      alias("prod", "prod-blue"),
+				"prod-blue": alias("prod-blue", "prod"),
+			},
+			wantErr: "alias cycle detected: prod -> prod-blue -> prod",
+		},
+		{
+			name:    "maximum depth",
+			cluster: "a",
+			mocks: map[string]u.ServeMockFunc{
+				"a": alias("a", "b"),
+				"b": alias("b", "c"),
+				"c": alias("c", "d"),
+				"d": alias("d", "e"),
+				"e": alias("e", "f"),
+				"f": alias("f", "g"),
+			},
+			wantErr: "alias chain exceeds the maximum depth of 5: a -> b -> c -> d -> e -> f",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm.ServeMocks = map[string]u.ServeMockFunc{
+				PATH_KV_MOUNT: (&mockData{kvVersion: "2"}).mockKVMount,
+			}
+			for name, mock := range tt.mocks {
+				vm.ServeMocks[PATH_BRO_CONFIG_BASE+name] = mock
+			}
+			client, err := vault.NewClient()
+			if err != nil {
+				t.Fatal(err)
+			}
+			reg, err := newRegistry(client)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			chain, path, err := resolveAliasChain(reg, tt.cluster)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantPath, path)
+			assert.Equal(t, "k8s-pki-17", chain[len(chain)-1].PKI)
+		})
+	}
+}
+
+func TestWriteKubeconfigAliasChain(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"prod"] = (&mockData{clusterName: "prod", serverURL: "prod.tsc.sh", aliasName: "prod-blue"}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"prod-blue"] = (&mockData{clusterName: "prod-blue", serverURL: "prod-blue.tsc.sh", aliasName: "cluster-17"}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"cluster-17"] = (&mockData{clusterName: "cluster-17", pkiName: "k8s-pki-17", serverURL: "cluster-17.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki-17", "master")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	kubeC, _, err := renderKubeconfig([]byte{}, "prod", "master", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "prod", got.CurrentContext)
+	assert.Equal(t, "prod.tsc.sh", got.Clusters[0].Cluster.Server)
+}
@@ -0,0 +1,177 @@
+package kube
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dbschenker/vaultpal/config"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+const (
+	KeyTypeRSA     = "rsa"
+	KeyTypeEC      = "ec"
+	KeyTypeEd25519 = "ed25519"
+
+	defaultCredentialTTL = time.Hour
+)
+
+var KeyTypes = []string{KeyTypeRSA, KeyTypeEC, KeyTypeEd25519}
+
+// credentialRequest are the parameters of the credentials requested from vault
+type credentialRequest struct {
+	TTL      time.Duration
+	KeyType  string
+	KeyBits  int
+	AltNames []string
+	// Sign generates the key locally and sends a certificate signing request to vault
+	Sign bool
+}
+
+// newCredentialRequest combines the options with the defaults and limits of the cluster.
+// Settings of an alias take precedence over those of the aliased cluster.
+func newCredentialRequest(cf config.KubeCluster, issuer config.KubeCluster, opts WriteOptions) (credentialRequest, error) {
+	settings := issuer.Credentials
+	if cf.Alias != "" {
+		settings = overlayCredentials(settings, cf.Credentials)
+	}
+
+	req := credentialRequest{
+		TTL:      opts.TTL,
+		KeyType:  opts.KeyType,
+		KeyBits:  opts.KeyBits,
+		AltNames: opts.AltNames,
+		Sign:     opts.Sign || settings.Sign,
+	}
+
+	if req.TTL == 0 {
+		ttl, err := parseTTL(settings.TTL)
+		if err != nil {
+			return req, errors.Wrapf(err, "invalid ttl of cluster %s", cf.Name)
+		}
+		req.TTL = ttl
+	}
+	if req.TTL == 0 {
+		req.TTL = defaultCredentialTTL
+	}
+
+	maxTTL, err := parseTTL(settings.MaxTTL)
+	if err != nil {
+		return req, errors.Wrapf(err, "invalid max_ttl of cluster %s", cf.Name)
+	}
+	if maxTTL > 0 && req.TTL > maxTTL {
+		return req, errors.Errorf("ttl %s exceeds the maximum %s of cluster %s", req.TTL, maxTTL, cf.Name)
+	}
+
+	if req.KeyType == "" {
+		req.KeyType = settings.KeyType
+		if req.KeyBits == 0 {
+			req.KeyBits = settings.KeyBits
+		}
+	}
+	if req.KeyType != "" && !contains(KeyTypes, req.KeyType) {
+		return req, errors.Errorf("unsupported key type [%s], must be one of %v", req.KeyType, KeyTypes)
+	}
+	if req.KeyBits != 0 && req.KeyType == "" {
+		return req, errors.New("key bits require a key type")
+	}
+
+	return req, nil
+}
+
+func overlayCredentials(base config.KubeCredentials, overlay config.KubeCredentials) config.KubeCredentials {
+	if overlay.TTL != "" {
+		base.TTL = overlay.TTL
+	}
+	if overlay.MaxTTL != "" {
+		base.MaxTTL = overlay.MaxTTL
+	}
+	if overlay.KeyType != "" {
+		base.KeyType = overlay.KeyType
+		base.KeyBits = overlay.KeyBits
+	}
+	if overlay.Sign {
+		base.Sign = true
+	}
+	return base
+}
+
+// parseTTL parses a duration like "8h" or a number of seconds, an empty ttl is 0
+func parseTTL(ttl string) (time.Duration, error) {
+	if ttl == "" {
+		return 0, nil
+	}
+	if seconds, err := strconv.Atoi(ttl); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(ttl)
+}
+
+// ttlSeconds renders a ttl as vault parameter
+func ttlSeconds(ttl time.Duration) string {
+	return strconv.Itoa(int(ttl.Seconds()))
+}
+
+// issuedExpiration returns the expiration reported by vault, which might be shorter than the requested ttl
+// when capped by the pki role. Falls back to the expiration of the certificate.
+func issuedExpiration(secret *api.Secret, certPEM string) (time.Time, error) {
+	if expiration, ok := secret.Data["expiration"].(json.Number); ok {
+		seconds, err := expiration.Int64()
+		if err == nil && seconds > 0 {
+			return time.Unix(seconds, 0), nil
+		}
+	}
+	return certificateExpiration(certPEM)
+}
+
+func privateKeyType(privateKeyPEM string) (string, int, error) {
+	block, _ := pem.Decode([]byte(privateKeyPEM))
+	if block == nil {
+		return "", 0, errors.New("no PEM encoded private key found")
+	}
+
+	var key interface{}
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return "", 0, errors.Wrap(err, "cannot parse private key")
+	}
+
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return KeyTypeRSA, k.N.BitLen(), nil
+	case *ecdsa.PrivateKey:
+		return KeyTypeEC, k.Curve.Params().BitSize, nil
+	case ed25519.PrivateKey:
+		return KeyTypeEd25519, 0, nil
+	default:
+		return "", 0, errors.Errorf("unsupported private key %T", key)
+	}
+}
+
+func formatKeyType(keyType string, keyBits int) string {
+	if keyBits == 0 {
+		return keyType
+	}
+	return fmt.Sprintf("%s %d", keyType, keyBits)
+}
+
+func joinAltNames(altNames []string) string {
+	return strings.Join(altNames, ",")
+}
@@ -0,0 +1,123 @@
+package kube
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dbschenker/vaultpal/config"
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCredentialRequest(t *testing.T) {
+	cluster := config.KubeCluster{
+		Name: "jim",
+		Credentials: config.KubeCredentials{
+			TTL:     "2h",
+			MaxTTL:  "28800",
+			KeyType: KeyTypeEC,
+			KeyBits: 256,
+		},
+	}
+	alias := config.KubeCluster{
+		Name:        "knopf",
+		Alias:       "jim",
+		Credentials: config.KubeCredentials{MaxTTL: "30m"},
+	}
+
+	tests := []struct {
+		name    string
+		cf      config.KubeCluster
+		issuer  config.KubeCluster
+		opts    WriteOptions
+		want    credentialRequest
+		wantErr string
+	}{
+		{name: "default ttl", cf: config.KubeCluster{Name: "jim"}, issuer: config.KubeCluster{Name: "jim"},
+			want: credentialRequest{TTL: time.Hour}},
+		{name: "registry defaults", cf: cluster, issuer: cluster,
+			want: credentialRequest{TTL: 2 * time.Hour, KeyType: KeyTypeEC, KeyBits: 256}},
+		{name: "flags override registry", cf: cluster, issuer: cluster, opts: WriteOptions{TTL: 8 * time.Hour, KeyType: KeyTypeRSA, AltNames: []string{"smurf.tsc.sh"}},
+			want: credentialRequest{TTL: 8 * time.Hour, KeyType: KeyTypeRSA, AltNames: []string{"smurf.tsc.sh"}}},
+		{name: "ttl exceeds max", cf: cluster, issuer: cluster, opts: WriteOptions{TTL: 9 * time.Hour},
+			wantErr: "ttl 9h0m0s exceeds the maximum 8h0m0s of cluster jim"},
+		{name: "alias limits the aliased cluster", cf: alias, issuer: cluster,
+			wantErr: "ttl 2h0m0s exceeds the maximum 30m0s of cluster knopf"},
+		{name: "unsupported key type", cf: cluster, issuer: cluster, opts: WriteOptions{KeyType: "dsa"},
+			wantErr: "unsupported key type [dsa], must be one of [rsa ec ed25519]"},
+		{name: "key bits without type", cf: config.KubeCluster{Name: "jim"}, issuer: config.KubeCluster{Name: "jim"}, opts: WriteOptions{KeyBits: 2048},
+			wantErr: "key bits require a key type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := newCredentialRequest(tt.cf, tt.issuer, tt.opts)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWriteKubeconfigCredentialSettings(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(8*time.Hour))
+	expiration := time.Now().Add(4 * time.Hour).Truncate(time.Second)
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh", credentials: map[string]interface{}{
+		"ttl":     "2h",
+		"max_ttl": "8h",
+	}}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+		body := map[string]interface{}{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "smurf", body["common_name"])
+		assert.Equal(t, "28800", body["ttl"])
+		assert.Equal(t, "smurf.tsc.sh,smurf.local", body["alt_names"])
+
+		// the pki role caps the ttl
+		w.Header().Set("Content-Type", "application/json")
+		u.WriteJsonResponse(t, api.Secret{Data: map[string]interface{}{
+			"issuing_ca":  CA,
+			"certificate": cert,
+			"private_key": key,
+			"expiration":  expiration.Unix(),
+		}}, w)
+	}
+
+	opts := WriteOptions{TTL: 8 * time.Hour, AltNames: []string{"smurf.tsc.sh", "smurf.local"}}
+	client, err := vault.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	reg, err := newRegistry(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, issuer, err := resolveCluster(reg, "jim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	creds, err := issueClusterCredentials(reg.client, cf, issuer, "master", "smurf", "master", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, expiration, creds.Expiration)
+
+	_, err = issueClusterCredentials(reg.client, cf, issuer, "master", "smurf", "master", WriteOptions{TTL: 9 * time.Hour})
+	assert.EqualError(t, err, "ttl 9h0m0s exceeds the maximum 8h0m0s of cluster jim")
+}
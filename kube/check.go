@@ -0,0 +1,231 @@
+package kube
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	authv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/discovery"
+	authclient "k8s.io/client-go/kubernetes/typed/authorization/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+const checkTimeout = 10 * time.Second
+
+// checkedAccess are the permissions probed with a SelfSubjectAccessReview in the namespace of the context
+var checkedAccess = []authv1.ResourceAttributes{
+	{Verb: "get", Resource: "pods"},
+	{Verb: "create", Resource: "pods"},
+	{Verb: "create", Resource: "pods", Subresource: "exec"},
+	{Verb: "list", Resource: "secrets"},
+	{Verb: "create", Group: "apps", Resource: "deployments"},
+	{Verb: "list", Resource: "namespaces"},
+}
+
+// CheckResult reports, if the api server of a context is reachable and what the user is allowed to do
+type CheckResult struct {
+	Context   string        `json:"context" yaml:"context"`
+	Server    string        `json:"server" yaml:"server"`
+	Version   string        `json:"version,omitempty" yaml:"version,omitempty"`
+	Namespace string        `json:"namespace" yaml:"namespace"`
+	Access    []AccessCheck `json:"access,omitempty" yaml:"access,omitempty"`
+	Rules     []AccessRule  `json:"rules,omitempty" yaml:"rules,omitempty"`
+	Error     string        `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// AccessCheck is the result of a SelfSubjectAccessReview
+type AccessCheck struct {
+	Verb     string `json:"verb" yaml:"verb"`
+	Resource string `json:"resource" yaml:"resource"`
+	Allowed  bool   `json:"allowed" yaml:"allowed"`
+}
+
+// AccessRule is a resource rule of a SelfSubjectRulesReview
+type AccessRule struct {
+	Verbs     []string `json:"verbs" yaml:"verbs"`
+	Resources []string `json:"resources" yaml:"resources"`
+}
+
+// Check verifies the context of cluster in the kubeconfig of target: the api server must be reachable
+// with the credentials of the context, and the permissions of the user in the namespace are reported.
+func Check(target string, cluster string, format string) error {
+	_, kubeConfigRaw, err := readTargetKubeConfig(target)
+	if err != nil {
+		return err
+	}
+
+	contextName, err := clusterContext(kubeConfigRaw, cluster)
+	if err != nil {
+		return err
+	}
+
+	return runCheck(os.Stdout, kubeConfigRaw, contextName, format)
+}
+
+// clusterContext returns the name of the context vaultpal wrote for cluster
+func clusterContext(kconfig []byte, cluster string) (string, error) {
+	k8, err := parseKubeConfig(kconfig)
+	if err != nil {
+		return "", err
+	}
+	for _, ce := range k8.Contexts {
+		if m := ce.Context.Metadata(); m != nil && m.Cluster == cluster {
+			return ce.Name, nil
+		}
+	}
+	return "", errors.Errorf("no context written by vaultpal found for cluster %s", cluster)
+}
+
+func runCheck(w io.Writer, kconfig []byte, contextName string, format string) error {
+	result := handleCheck(kconfig, contextName)
+
+	err := utils.WriteOutput(w, format, result, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "Context:\t%s\n", result.Context)
+		_, _ = fmt.Fprintf(w, "Server:\t%s\n", result.Server)
+		_, _ = fmt.Fprintf(w, "Version:\t%s\n", orNone(result.Version))
+		_, _ = fmt.Fprintf(w, "Namespace:\t%s\n", orNone(result.Namespace))
+		for _, a := range result.Access {
+			_, _ = fmt.Fprintf(w, "Can %s %s:\t%s\n", a.Verb, a.Resource, yesNo(a.Allowed))
+		}
+		for _, r := range result.Rules {
+			_, _ = fmt.Fprintf(w, "Rule:\t%s on %s\n", strings.Join(r.Verbs, ","), strings.Join(r.Resources, ","))
+		}
+		if result.Error != "" {
+			_, _ = fmt.Fprintf(w, "Error:\t%s\n", result.Error)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if result.Error != "" {
+		return errors.Errorf("check of context %s failed: %s", contextName, result.Error)
+	}
+	if len(result.Rules) == 0 && !anyAllowed(result.Access) {
+		log.WithField("Namespace", result.Namespace).Warn("the role grants no permissions in the namespace, check the RBAC bindings of the cluster")
+	}
+	return nil
+}
+
+// handleCheck calls /version of the api server of the context and reviews the access of the user in its namespace
+func handleCheck(kconfig []byte, contextName string) CheckResult {
+	result := CheckResult{Context: contextName}
+
+	restConfig, namespace, err := contextRestConfig(kconfig, contextName)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Server = restConfig.Host
+	result.Namespace = namespace
+	restConfig.Timeout = checkTimeout
+	restConfig.ContentType = runtime.ContentTypeJSON
+	restConfig.AcceptContentTypes = runtime.ContentTypeJSON
+
+	dc, err := discovery.NewDiscoveryClientForConfig(restConfig)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	version, err := dc.ServerVersion()
+	if err != nil {
+		result.Error = errors.Wrap(err, "api server not reachable").Error()
+		return result
+	}
+	result.Version = version.GitVersion
+
+	ac, err := authclient.NewForConfig(restConfig)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkTimeout)
+	defer cancel()
+
+	for _, attrs := range checkedAccess {
+		attrs := attrs
+		attrs.Namespace = namespace
+		review, err := ac.SelfSubjectAccessReviews().Create(ctx, &authv1.SelfSubjectAccessReview{
+			Spec: authv1.SelfSubjectAccessReviewSpec{ResourceAttributes: &attrs},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			result.Error = errors.Wrap(err, "access review failed").Error()
+			return result
+		}
+		result.Access = append(result.Access, AccessCheck{
+			Verb:     attrs.Verb,
+			Resource: resourceName(attrs),
+			Allowed:  review.Status.Allowed,
+		})
+	}
+
+	rules, err := ac.SelfSubjectRulesReviews().Create(ctx, &authv1.SelfSubjectRulesReview{
+		Spec: authv1.SelfSubjectRulesReviewSpec{Namespace: namespace},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		// not every authorizer supports rules reviews, the access reviews are reported anyway
+		log.WithError(err).Debug("rules review failed")
+		return result
+	}
+	for _, r := range rules.Status.ResourceRules {
+		result.Rules = append(result.Rules, AccessRule{Verbs: r.Verbs, Resources: r.Resources})
+	}
+	return result
+}
+
+// contextRestConfig returns the client configuration and the namespace of a kubeconfig context
+func contextRestConfig(kconfig []byte, contextName string) (*rest.Config, string, error) {
+	apiConfig, err := clientcmd.Load(kconfig)
+	if err != nil {
+		return nil, "", errors.Wrap(err, "cannot load kubeconfig")
+	}
+	clientConfig := clientcmd.NewNonInteractiveClientConfig(*apiConfig, contextName, &clientcmd.ConfigOverrides{}, nil)
+
+	restConfig, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "invalid context %s", contextName)
+	}
+	namespace, _, err := clientConfig.Namespace()
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "invalid context %s", contextName)
+	}
+	return restConfig, namespace, nil
+}
+
+func resourceName(attrs authv1.ResourceAttributes) string {
+	name := attrs.Resource
+	if attrs.Group != "" {
+		name += "." + attrs.Group
+	}
+	if attrs.Subresource != "" {
+		name += "/" + attrs.Subresource
+	}
+	return name
+}
+
+func anyAllowed(access []AccessCheck) bool {
+	for _, a := range access {
+		if a.Allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
@@ -0,0 +1,104 @@
+package kube
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	authv1 "k8s.io/api/authorization/v1"
+	"k8s.io/apimachinery/pkg/version"
+)
+
+// newAPIServerMock serves the endpoints of the api server used by the check, allowing the verbs of allowed
+func newAPIServerMock(t *testing.T, allowed ...string) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/version", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(version.Info{GitVersion: "v1.30.2"})
+	})
+	mux.HandleFunc("/apis/authorization.k8s.io/v1/selfsubjectaccessreviews", func(w http.ResponseWriter, r *http.Request) {
+		review := authv1.SelfSubjectAccessReview{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, "master", review.Spec.ResourceAttributes.Namespace)
+		review.Status.Allowed = contains(allowed, review.Spec.ResourceAttributes.Verb)
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(review)
+	})
+	mux.HandleFunc("/apis/authorization.k8s.io/v1/selfsubjectrulesreviews", func(w http.ResponseWriter, r *http.Request) {
+		review := authv1.SelfSubjectRulesReview{}
+		if err := json.NewDecoder(r.Body).Decode(&review); err != nil {
+			t.Fatal(err)
+		}
+		if len(allowed) > 0 {
+			review.Status.ResourceRules = []authv1.ResourceRule{{Verbs: allowed, Resources: []string{"pods"}}}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(review)
+	})
+	return httptest.NewServer(mux)
+}
+
+func writeCheckKubeconfig(t *testing.T, server string) []byte {
+	vm := u.NewVaultServerMock(t)
+	t.Cleanup(vm.CloseServer)
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: server}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	kubeC, _, err := renderKubeconfig([]byte{}, "jim", "master", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return kubeC
+}
+
+func TestCheck(t *testing.T) {
+	apiServer := newAPIServerMock(t, "get", "list")
+	defer apiServer.Close()
+	kubeC := writeCheckKubeconfig(t, apiServer.URL)
+
+	contextName, err := clusterContext(kubeC, "jim")
+	assert.NoError(t, err)
+	assert.Equal(t, "jim", contextName)
+
+	got := handleCheck(kubeC, contextName)
+	assert.Equal(t, CheckResult{
+		Context:   "jim",
+		Server:    apiServer.URL,
+		Version:   "v1.30.2",
+		Namespace: "master",
+		Access: []AccessCheck{
+			{Verb: "get", Resource: "pods", Allowed: true},
+			{Verb: "create", Resource: "pods"},
+			{Verb: "create", Resource: "pods/exec"},
+			{Verb: "list", Resource: "secrets", Allowed: true},
+			{Verb: "create", Resource: "deployments.apps"},
+			{Verb: "list", Resource: "namespaces", Allowed: true},
+		},
+		Rules: []AccessRule{{Verbs: []string{"get", "list"}, Resources: []string{"pods"}}},
+	}, got)
+}
+
+func TestCheckUnreachable(t *testing.T) {
+	apiServer := newAPIServerMock(t)
+	kubeC := writeCheckKubeconfig(t, apiServer.URL)
+	apiServer.Close()
+
+	got := handleCheck(kubeC, "jim")
+	assert.Contains(t, got.Error, "api server not reachable")
+	assert.Empty(t, got.Access)
+
+	_, err := clusterContext(kubeC, "emma")
+	assert.EqualError(t, err, "no context written by vaultpal found for cluster emma")
+}
@@ -0,0 +1,244 @@
+package kube
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/dbschenker/vaultpal/config"
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// ClusterInfo describes a cluster of the registry with its resolved pki or kubernetes secrets engine
+type ClusterInfo struct {
+	Name       string            `json:"name" yaml:"name"`
+	Server     string            `json:"server" yaml:"server"`
+	Alias      string            `json:"alias,omitempty" yaml:"alias,omitempty"`
+	Path       []string          `json:"path,omitempty" yaml:"path,omitempty"`
+	Target     string            `json:"target,omitempty" yaml:"target,omitempty"`
+	Auth       string            `json:"auth" yaml:"auth"`
+	PKI        string            `json:"pki,omitempty" yaml:"pki,omitempty"`
+	Kubernetes string            `json:"kubernetes,omitempty" yaml:"kubernetes,omitempty"`
+	Roles      []string          `json:"roles,omitempty" yaml:"roles,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+	Error      string            `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// mount returns the vault mount issuing the credentials of the cluster
+func (c ClusterInfo) mount() string {
+	if c.Auth == config.KubeAuthToken {
+		return c.Kubernetes
+	}
+	return c.PKI
+}
+
+// ListClusters prints all clusters of the registry
+func ListClusters(format string) error {
+	client, err := vault.NewClient()
+	if err != nil {
+		return errors.Wrap(err, "error creating vault api client")
+	}
+
+	clusters, err := handleListClusters(client)
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteOutput(os.Stdout, format, clusters, func(w io.Writer) {
+		_, _ = fmt.Fprintln(w, "NAME\tSERVER\tALIAS\tAUTH\tMOUNT")
+		for _, c := range clusters {
+			mount := c.mount()
+			if c.Error != "" {
+				mount = "<" + c.Error + ">"
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", c.Name, c.Server, orNone(c.Alias), orNone(c.Auth), mount)
+		}
+	})
+}
+
+// DescribeCluster prints a cluster of the registry and the roles, the current token can issue credentials for
+func DescribeCluster(cluster string, format string) error {
+	client, err := vault.NewClient()
+	if err != nil {
+		return errors.Wrap(err, "error creating vault api client")
+	}
+
+	info, err := handleDescribeCluster(client, cluster)
+	if err != nil {
+		return err
+	}
+
+	return utils.WriteOutput(os.Stdout, format, info, func(w io.Writer) {
+		_, _ = fmt.Fprintf(w, "Name:\t%s\n", info.Name)
+		_, _ = fmt.Fprintf(w, "Server:\t%s\n", info.Server)
+		_, _ = fmt.Fprintf(w, "Alias:\t%s\n", orNone(info.Alias))
+		_, _ = fmt.Fprintf(w, "Auth:\t%s\n", info.Auth)
+		_, _ = fmt.Fprintf(w, "Mount:\t%s\n", info.mount())
+		_, _ = fmt.Fprintf(w, "Roles:\t%s\n", orNone(strings.Join(info.Roles, ", ")))
+		_, _ = fmt.Fprintf(w, "Labels:\t%s\n", orNone(formatLabels(info.Labels)))
+	})
+}
+
+func handleListClusters(client *api.Client) ([]ClusterInfo, error) {
+	reg, err := newRegistry(client)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := reg.list()
+	if err != nil {
+		return nil, err
+	}
+
+	clusters := make([]ClusterInfo, 0, len(names))
+	for _, name := range names {
+		info, err := clusterInfo(reg, name)
+		if err != nil {
+			// a broken entry must not hide all the others
+			log.WithError(err).Warnf("invalid definition of cluster [%s]", name)
+			info.Error = err.Error()
+		}
+		clusters = append(clusters, info)
+	}
+	return clusters, nil
+}
+
+func handleDescribeCluster(client *api.Client, cluster string) (ClusterInfo, error) {
+	reg, err := newRegistry(client)
+	if err != nil {
+		return ClusterInfo{}, err
+	}
+
+	info, err := clusterInfo(reg, cluster)
+	if err != nil {
+		return info, err
+	}
+
+	if info.Auth == config.KubeAuthToken {
+		info.Roles, err = issuableRoles(client, info.Kubernetes, "creds")
+	} else {
+		info.Roles, err = issuableRoles(client, info.PKI, "issue")
+	}
+	if err != nil {
+		// missing permissions only hide the roles, an unreachable vault fails the command
+		var unreachable *vault.UnreachableError
+		if errors.As(err, &unreachable) {
+			return info, err
+		}
+		log.WithError(err).Warnf("cannot determine the roles of [%s]", info.mount())
+	}
+	return info, nil
+}
+
+// clusterInfo reads a cluster definition and resolves the pki or kubernetes secrets engine of an alias
+func clusterInfo(reg *registry, name string) (ClusterInfo, error) {
+	info := ClusterInfo{Name: name}
+
+	cf, err := reg.read(name)
+	if err != nil {
+		return info, err
+	}
+	if cf == nil {
+		return info, errors.Errorf("cluster %s is undefined", name)
+	}
+	info.Server = cf.Server
+	info.Alias = cf.Alias
+	info.Auth = cf.AuthMode()
+	info.PKI = cf.PKI
+	info.Kubernetes = cf.Kubernetes
+	info.Labels = cf.Labels
+
+	err = verifyPalKubeConfig(*cf)
+	if err != nil {
+		return info, err
+	}
+
+	if cf.Alias != "" {
+		chain, path, err := resolveAliasChain(reg, name)
+		info.Path = path
+		if err != nil {
+			return info, errors.Wrapf(err, "cannot resolve alias %s", name)
+		}
+		target := chain[len(chain)-1]
+		info.Target = path[len(path)-1]
+		info.Auth = target.AuthMode()
+		info.Labels = clusterLabels(*cf, target)
+		info.PKI = target.PKI
+		info.Kubernetes = target.Kubernetes
+	}
+	return info, nil
+}
+
+// issuableRoles lists the roles of a pki or kubernetes secrets engine, the current token is allowed to
+// issue credentials for at <mount>/<action>/<role>
+func issuableRoles(client *api.Client, mount string, action string) ([]string, error) {
+	secret, err := client.Logical().List(mount + "/roles")
+	if err != nil {
+		return nil, errors.Wrapf(vault.CheckReachable(client, err), "error listing roles of [%s]", mount)
+	}
+	if secret == nil {
+		return []string{}, nil
+	}
+
+	keys, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil, errors.Errorf("unexpected list response of [%s]", mount)
+	}
+
+	paths := make([]string, 0, len(keys))
+	for _, k := range keys {
+		paths = append(paths, fmt.Sprintf("%s/%s/%v", mount, action, k))
+	}
+
+	caps, err := client.Logical().Write("sys/capabilities-self", map[string]interface{}{
+		"paths": paths,
+	})
+	if err != nil {
+		return nil, errors.Wrap(vault.CheckReachable(client, err), "error looking up token capabilities")
+	}
+
+	roles := []string{}
+	for i, p := range paths {
+		if caps != nil && canWrite(caps.Data[p]) {
+			roles = append(roles, fmt.Sprint(keys[i]))
+		}
+	}
+	sort.Strings(roles)
+	return roles, nil
+}
+
+func canWrite(capabilities interface{}) bool {
+	list, ok := capabilities.([]interface{})
+	if !ok {
+		return false
+	}
+	for _, c := range list {
+		switch c {
+		case "create", "update", "root":
+			return true
+		}
+	}
+	return false
+}
+
+func formatLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, k+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ", ")
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
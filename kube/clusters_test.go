@@ -0,0 +1,127 @@
+package kube
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	PATH_BRO_CONFIG_LIST   = "/v1/kv/metadata/vaultbro/k8s/clusters"
+	PATH_CAPABILITIES_SELF = "/v1/sys/capabilities-self"
+)
+
+func mockList(keys ...string) u.ServeMockFunc {
+	return func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		sec := api.Secret{Data: map[string]interface{}{
+			"keys": keys,
+		}}
+		u.WriteJsonResponse(t, sec, w)
+	}
+}
+
+func mockCapabilities(caps map[string][]string) u.ServeMockFunc {
+	return func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		data := map[string]interface{}{}
+		for p, c := range caps {
+			data[p] = c
+		}
+		u.WriteJsonResponse(t, api.Secret{Data: data}, w)
+	}
+}
+
+func TestListClusters(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_LIST] = mockList("jim", "lukas", "emma", "archive/")
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"lukas"] = (&mockData{clusterName: "lukas", serverURL: "lukas.tsc.sh", aliasName: "jim"}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"emma"] = (&mockData{clusterName: "emma", pkiName: "k8s-pki-emma"}).mockReadPalConfig
+
+	client, err := vault.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusters, err := handleListClusters(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, []ClusterInfo{
+		{Name: "emma", Auth: "certificate", PKI: "k8s-pki-emma", Error: "server must not be empty"},
+		{Name: "jim", Server: "jim-knopf.tsc.sh", Auth: "certificate", PKI: "k8s-pki"},
+		{Name: "lukas", Server: "lukas.tsc.sh", Alias: "jim", Path: []string{"lukas", "jim"}, Target: "jim", Auth: "certificate", PKI: "k8s-pki"},
+	}, clusters)
+}
+
+func TestDescribeCluster(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"lukas"] = (&mockData{clusterName: "lukas", serverURL: "lukas.tsc.sh", aliasName: "jim"}).mockReadPalConfig
+	vm.ServeMocks["/v1/k8s-pki/roles"] = mockList("master", "admin", "readonly")
+	vm.ServeMocks[PATH_CAPABILITIES_SELF] = mockCapabilities(map[string][]string{
+		"k8s-pki/issue/master":   {"update"},
+		"k8s-pki/issue/admin":    {"deny"},
+		"k8s-pki/issue/readonly": {"create", "read"},
+	})
+
+	client, err := vault.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := handleDescribeCluster(client, "lukas")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, ClusterInfo{
+		Name:   "lukas",
+		Server: "lukas.tsc.sh",
+		Alias:  "jim",
+		Path:   []string{"lukas", "jim"},
+		Target: "jim",
+		Auth:   "certificate",
+		PKI:    "k8s-pki",
+		Roles:  []string{"master", "readonly"},
+	}, info)
+}
+
+func TestDescribeClusterUnreachable(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	// vault goes away while listing the roles
+	vm.ServeMocks["/v1/k8s-pki/roles"] = func(t *testing.T, w http.ResponseWriter, r *http.Request) {
+		conn, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = conn.Close()
+	}
+
+	client, err := vault.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetMaxRetries(0)
+	_, err = handleDescribeCluster(client, "jim")
+	var unreachable *vault.UnreachableError
+	assert.ErrorAs(t, err, &unreachable)
+}
@@ -0,0 +1,123 @@
+package kube
+
+import (
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// UseContext makes the context of cluster the current context of the kubeconfig of target.
+// The credentials are only reissued, if they are expired or missing.
+func UseContext(target string, cluster string) error {
+	kubeconfigFile, err := targetKubeConfigFile(target)
+	if err != nil {
+		return err
+	}
+
+	var replaced *replacedCredentials
+	err = updateKubeConfig(target, kubeconfigFile, func(kconfig []byte) ([]byte, error) {
+		var out []byte
+		out, replaced, err = handleUseContext(kconfig, cluster, target)
+		return out, err
+	})
+	if err != nil {
+		return err
+	}
+	// the replaced credentials are revoked only after the new ones are stored
+	if replaced != nil {
+		replaced.revoke()
+	}
+	return nil
+}
+
+// handleUseContext returns the kubeconfig with the context of cluster as current context, and the credentials
+// replaced by reissuing them, which are to be revoked once the kubeconfig is stored
+func handleUseContext(kconfig []byte, cluster string, target string) ([]byte, *replacedCredentials, error) {
+	k8, err := parseKubeConfig(kconfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	contextName, err := clusterContext(kconfig, cluster)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "login to cluster %s first", cluster)
+	}
+
+	for _, t := range refreshTargets(k8) {
+		if t.Context != contextName || !credentialsStale(k8, t.Context) {
+			continue
+		}
+		log.WithFields(log.Fields{
+			"Context": t.Context,
+			"Role":    t.Role,
+		}).Info("credentials expired, reissue them")
+		return renderKubeconfig(kconfig, t.Cluster, t.Role, t.writeOptions(target))
+	}
+
+	k8.CurrentContext = contextName
+	out, err := yaml.Marshal(k8)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot marshal kubeconfig file")
+	}
+	log.WithField("Context", contextName).Info("switched context")
+	return out, nil, nil
+}
+
+// credentialsStale reports, if the user of the context is missing or its credentials are expired
+func credentialsStale(k8 *Config, contextName string) bool {
+	for _, ce := range k8.Contexts {
+		if ce.Name != contextName {
+			continue
+		}
+		for _, ue := range k8.Users {
+			if ue.Name == ce.Context.User {
+				return userExpired(ue.User)
+			}
+		}
+	}
+	return true
+}
+
+// SetNamespace changes the namespace of the current context of the kubeconfig of target
+func SetNamespace(target string, namespace string) error {
+	kubeconfigFile, err := targetKubeConfigFile(target)
+	if err != nil {
+		return err
+	}
+
+	return updateKubeConfig(target, kubeconfigFile, func(kconfig []byte) ([]byte, error) {
+		return handleSetNamespace(kconfig, namespace)
+	})
+}
+
+func handleSetNamespace(kconfig []byte, namespace string) ([]byte, error) {
+	k8, err := parseKubeConfig(kconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	if k8.CurrentContext == "" {
+		return nil, errors.New("no current context, switch to a context first")
+	}
+
+	found := false
+	for i, ce := range k8.Contexts {
+		if ce.Name == k8.CurrentContext {
+			k8.Contexts[i].Context.Namespace = namespace
+			found = true
+		}
+	}
+	if !found {
+		return nil, errors.Errorf("current context %s does not exist", k8.CurrentContext)
+	}
+
+	out, err := yaml.Marshal(k8)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal kubeconfig file")
+	}
+	log.WithFields(log.Fields{
+		"Context":   k8.CurrentContext,
+		"Namespace": namespace,
+	}).Info("switched namespace")
+	return out, nil
+}
@@ -0,0 +1,114 @@
+package kube
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestUseContext(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"lukas"] = (&mockData{clusterName: "lukas", pkiName: "k8s-pki", serverURL: "lukas.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	raw := staleKubeConfig(t, "lukas")
+
+	// valid credentials are not reissued, the mock server fails on unexpected requests
+	out, _, err := handleUseContext(raw, "jim", TargetVaultpal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseKubeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "jim", got.CurrentContext)
+
+	// expired credentials are reissued
+	out, _, err = handleUseContext(out, "lukas", TargetVaultpal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err = parseKubeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "lukas", got.CurrentContext)
+	assert.False(t, credentialsStale(got, "lukas"))
+
+	_, _, err = handleUseContext(out, "ghost", TargetVaultpal)
+	assert.EqualError(t, err, "login to cluster ghost first: no context written by vaultpal found for cluster ghost")
+}
+
+func TestUseContextKeepsWriteOptions(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	// there is no mock of pki/issue, so the signed context must not be reissued by vault
+	vm.ServeMocks[fmt.Sprintf(PATH_SIGN_CERT_F, "k8s-pki", "master")] = mockSignCert
+
+	kubeC, _, err := renderKubeconfig([]byte{}, "jim", "master", WriteOptions{Sign: true, KeyType: KeyTypeEC})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the credentials of the context expired
+	k8, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiredCert, expiredKey := newTestCertificate(t, "smurf", time.Now().Add(-time.Minute))
+	k8.Users[0].User = User{ClientCertificateData: StringToBase64String(expiredCert), ClientKeyData: StringToBase64String(expiredKey)}
+	kubeC, err = yaml.Marshal(k8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, _, err := handleUseContext(kubeC, "jim", TargetVaultpal)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseKubeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.False(t, credentialsStale(got, "jim"))
+	keyPEM, err := base64.StdEncoding.DecodeString(got.Users[0].User.ClientKeyData)
+	assert.NoError(t, err)
+	keyType, _, err := privateKeyType(string(keyPEM))
+	assert.NoError(t, err)
+	assert.Equal(t, KeyTypeEC, keyType)
+}
+
+func TestSetNamespace(t *testing.T) {
+	out, err := handleSetNamespace(staleKubeConfig(t), "webclaims-ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseKubeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "master", got.Contexts[0].Context.Namespace)
+	assert.Equal(t, "webclaims-ci", got.Contexts[2].Context.Namespace)
+
+	_, err = handleSetNamespace([]byte{}, "webclaims-ci")
+	assert.EqualError(t, err, "no current context, switch to a context first")
+}
@@ -0,0 +1,349 @@
+package kube
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	clientauthv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+)
+
+const (
+	ENV_VAULTPAL_KUBE_CACHE_DIR = "VAULTPAL_KUBE_CACHE_DIR"
+
+	execCredentialApiVersion = "client.authentication.k8s.io/v1"
+	execCredentialKind       = "ExecCredential"
+	execCommand              = "vaultpal"
+
+	// cached credentials are reissued, if they expire within this period
+	credentialRenewBefore = 5 * time.Minute
+	credentialCacheExt    = ".json"
+)
+
+// credentials are the client certificate and key, or the service account token issued by vault for a kubeconfig user
+type credentials struct {
+	VaultAddress string    `json:"vault_address"`
+	Certificate  string    `json:"certificate,omitempty"`
+	PrivateKey   string    `json:"private_key,omitempty"`
+	IssuingCA    string    `json:"issuing_ca,omitempty"`
+	Token        string    `json:"token,omitempty"`
+	Serial       string    `json:"serial,omitempty"`
+	Expiration   time.Time `json:"expiration"`
+}
+
+func (c *credentials) validFor(d time.Duration) bool {
+	return time.Now().Add(d).Before(c.Expiration)
+}
+
+// WriteExecCredential prints an ExecCredential for the cluster and role to stdout.
+// It is meant to be called by kubectl as exec credential plugin.
+func WriteExecCredential(cluster string, role string, opts WriteOptions) error {
+	out, err := handleExecCredential(cluster, role, opts)
+	if err != nil {
+		return err
+	}
+	_, _ = os.Stdout.Write(out)
+	return nil
+}
+
+func handleExecCredential(cluster string, role string, opts WriteOptions) ([]byte, error) {
+	client, err := vault.NewClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating vault api client")
+	}
+
+	cache, err := newCredentialCache(client, credentialExecConfig(cluster, role, opts))
+	if err != nil {
+		return nil, err
+	}
+
+	creds, err := cache.read()
+	if err != nil {
+		log.WithError(err).Debug("no cached credentials")
+	}
+
+	if creds == nil || !creds.validFor(credentialRenewBefore) {
+		user, err := lookupIdentity(client)
+		if err != nil {
+			return nil, err
+		}
+
+		reg, err := newRegistry(client)
+		if err != nil {
+			return nil, err
+		}
+
+		cf, issuer, err := resolveCluster(reg, cluster)
+		if err != nil {
+			return nil, err
+		}
+
+		namespace, err := resolveNamespace(role, opts.Namespace, cf, issuer)
+		if err != nil {
+			return nil, err
+		}
+
+		creds, err = issueClusterCredentials(client, cf, issuer, role, user, namespace, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		err = cache.write(creds)
+		if err != nil {
+			log.WithError(err).Warn("cannot cache issued credentials")
+		}
+	}
+
+	execCredential := clientauthv1.ExecCredential{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: execCredentialApiVersion,
+			Kind:       execCredentialKind,
+		},
+		Status: &clientauthv1.ExecCredentialStatus{
+			ExpirationTimestamp:   &metav1.Time{Time: creds.Expiration},
+			ClientCertificateData: creds.Certificate,
+			ClientKeyData:         creds.PrivateKey,
+			Token:                 creds.Token,
+		},
+	}
+
+	out, err := json.Marshal(execCredential)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal exec credential")
+	}
+	return out, nil
+}
+
+// credentialExecConfig renders the kubeconfig user stanza calling `vaultpal kube credential`
+func credentialExecConfig(cluster string, role string, opts WriteOptions) *ExecConfig {
+	args := []string{"kube", "credential", cluster, role}
+	if opts.ClusterRoleBinding {
+		args = append(args, "--cluster-role-binding")
+	}
+	if opts.Namespace != "" {
+		args = append(args, "--namespace", opts.Namespace)
+	}
+	if opts.TTL != 0 {
+		args = append(args, "--ttl", opts.TTL.String())
+	}
+	if opts.KeyType != "" {
+		args = append(args, "--key-type", opts.KeyType)
+	}
+	if opts.KeyBits != 0 {
+		args = append(args, "--key-bits", strconv.Itoa(opts.KeyBits))
+	}
+	if len(opts.AltNames) > 0 {
+		args = append(args, "--alt-names", joinAltNames(opts.AltNames))
+	}
+	if opts.Sign {
+		args = append(args, "--sign")
+	}
+	return &ExecConfig{
+		ApiVersion:      execCredentialApiVersion,
+		Command:         execCommand,
+		Args:            args,
+		InteractiveMode: "Never",
+	}
+}
+
+func credentialCacheDir() (string, error) {
+	if envCacheDir := os.Getenv(ENV_VAULTPAL_KUBE_CACHE_DIR); envCacheDir != "" {
+		return envCacheDir, nil
+	}
+
+	home, err := homedir.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".vaultpal", "kube", "cache"), nil
+}
+
+// credentialCache is the cache file of the credentials requested by an exec credential plugin stanza. The file is
+// named after the cluster and role, a hash of the vault address and all args of the stanza, and a hash of the
+// vault token, so credentials are neither shared between vault tokens nor between requests with different options.
+// Hashing the token locally lets kubectl use cached credentials without asking vault.
+type credentialCache struct {
+	file string
+}
+
+// newCredentialCache returns the cache of the exec config for the token of client
+func newCredentialCache(client *api.Client, e *ExecConfig) (*credentialCache, error) {
+	token := client.Token()
+	if token == "" {
+		return nil, errors.New("no vault token to cache credentials for")
+	}
+
+	prefix, err := credentialCachePrefix(e)
+	if err != nil {
+		return nil, err
+	}
+	return &credentialCache{file: prefix + "_" + shortHash(os.Getenv(api.EnvVaultAddress), token) + credentialCacheExt}, nil
+}
+
+// credentialCaches returns the caches of the exec config for all vault tokens of the current vault address
+func credentialCaches(e *ExecConfig) ([]*credentialCache, error) {
+	prefix, err := credentialCachePrefix(e)
+	if err != nil {
+		return nil, err
+	}
+	files, err := filepath.Glob(prefix + "_*" + credentialCacheExt)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot list cached credentials [%s]", prefix)
+	}
+	caches := []*credentialCache{}
+	for _, f := range files {
+		caches = append(caches, &credentialCache{file: f})
+	}
+	return caches, nil
+}
+
+func credentialCachePrefix(e *ExecConfig) (string, error) {
+	cluster, role, ok := vaultpalExecTarget(e)
+	if !ok {
+		return "", errors.Errorf("exec command %s is not managed by vaultpal", e.Command)
+	}
+	dir, err := credentialCacheDir()
+	if err != nil {
+		return "", err
+	}
+	request := shortHash(append([]string{os.Getenv(api.EnvVaultAddress)}, e.Args...)...)
+	return filepath.Join(dir, cluster+"_"+role+"_"+request), nil
+}
+
+func shortHash(s ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(s, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// read returns the cached credentials, or nil if there are none for the current vault address
+func (c *credentialCache) read() (*credentials, error) {
+	raw, err := os.ReadFile(c.file)
+	if err != nil {
+		return nil, err
+	}
+
+	creds := &credentials{}
+	err = json.Unmarshal(raw, creds)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot unmarshal cached credentials [%s]", c.file)
+	}
+
+	if creds.VaultAddress != os.Getenv(api.EnvVaultAddress) {
+		return nil, nil
+	}
+	return creds, nil
+}
+
+// write stores the credentials in the cache file and removes cache files of expired credentials
+func (c *credentialCache) write(creds *credentials) error {
+	err := os.MkdirAll(filepath.Dir(c.file), 0700)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create credential cache dir [%s]", filepath.Dir(c.file))
+	}
+
+	creds.VaultAddress = os.Getenv(api.EnvVaultAddress)
+	raw, err := json.Marshal(creds)
+	if err != nil {
+		return errors.Wrap(err, "cannot marshal credentials")
+	}
+
+	err = utils.WriteFileAtomic(c.file, raw, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "cannot write cached credentials to [%s]", c.file)
+	}
+
+	pruneCredentialCache(c.file)
+	return nil
+}
+
+func (c *credentialCache) remove() error {
+	err := os.Remove(c.file)
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrapf(err, "cannot remove cached credentials [%s]", c.file)
+	}
+	return nil
+}
+
+// pruneCredentialCache removes the cache files next to file holding expired credentials, e.g. the ones of previous vault tokens
+func pruneCredentialCache(file string) {
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(file), "*"+credentialCacheExt))
+	if err != nil {
+		return
+	}
+	for _, f := range files {
+		if f == file {
+			continue
+		}
+		raw, err := os.ReadFile(f)
+		if err != nil {
+			continue
+		}
+		creds := &credentials{}
+		if json.Unmarshal(raw, creds) == nil && !creds.validFor(0) {
+			_ = os.Remove(f)
+		}
+	}
+}
+
+func parseCertificate(certPEM string) (*x509.Certificate, error) {
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil {
+		return nil, errors.New("no PEM encoded certificate found")
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot parse certificate")
+	}
+	return cert, nil
+}
+
+// tokenClaims are the registered claims of a service account token
+type tokenClaims struct {
+	Subject  string `json:"sub"`
+	IssuedAt int64  `json:"iat"`
+	Expiry   int64  `json:"exp"`
+}
+
+// parseTokenClaims decodes the claims of a JWT without verifying its signature
+func parseTokenClaims(token string) (*tokenClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed service account token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot decode service account token")
+	}
+	claims := &tokenClaims{}
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot unmarshal service account token claims")
+	}
+	if claims.Expiry == 0 {
+		return nil, errors.New("service account token does not expire")
+	}
+	return claims, nil
+}
+
+func certificateExpiration(certPEM string) (time.Time, error) {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return cert.NotAfter, nil
+}
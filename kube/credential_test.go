@@ -0,0 +1,214 @@
+package kube
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	clientauthv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+)
+
+// newTestCertificate creates a self signed client certificate for cn, which is valid until notAfter
+func newTestCertificate(t *testing.T, cn string, notAfter time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notAfter.Add(-time.Hour),
+		NotAfter:     notAfter,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return string(certPEM), string(keyPEM)
+}
+
+func TestExecCredentialCache(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	os.Setenv(ENV_VAULTPAL_KUBE_CACHE_DIR, t.TempDir())
+
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour).Truncate(time.Second))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	out, err := handleExecCredential("jim", "master", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := clientauthv1.ExecCredential{}
+	err = json.Unmarshal(out, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "client.authentication.k8s.io/v1", got.APIVersion)
+	assert.Equal(t, "ExecCredential", got.Kind)
+	assert.Equal(t, cert, got.Status.ClientCertificateData)
+	assert.Equal(t, key, got.Status.ClientKeyData)
+
+	// vault must not be called again, as long as the cached certificate is valid
+	vm.ServeMocks = map[string]u.ServeMockFunc{}
+	cachedOut, err := handleExecCredential("jim", "master", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.JSONEq(t, string(out), string(cachedOut))
+
+	// the cached certificate is not handed out to another vault token
+	os.Setenv(api.EnvVaultToken, "5678")
+	otherCert, otherKey := newTestCertificate(t, "gargamel", time.Now().Add(time.Hour).Truncate(time.Second))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "gargamel"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: otherCert, privateKey: otherKey}).mockIssueCert
+	otherOut, err := handleExecCredential("jim", "master", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = clientauthv1.ExecCredential{}
+	err = json.Unmarshal(otherOut, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, otherCert, got.Status.ClientCertificateData)
+}
+
+func TestExecCredentialCachePerNamespace(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	os.Setenv(ENV_VAULTPAL_KUBE_CACHE_DIR, t.TempDir())
+	pki := newMockPKI(t, vm)
+
+	for _, namespace := range []string{"ttb-int", "ttb-prod", "ttb-int"} {
+		_, err := handleExecCredential("jim", "master", WriteOptions{Namespace: namespace})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	// each namespace is issued credentials of its own, which are cached separately
+	assert.Equal(t, 2, pki.issued)
+
+	for i, namespace := range []string{"ttb-int", "ttb-prod"} {
+		cached, err := testCredentialCache(t, credentialExecConfig("jim", "master", WriteOptions{Namespace: namespace})).read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, fmt.Sprintf("39:dd:2e:%02d", i+1), cached.Serial)
+	}
+}
+
+// testCredentialCache returns the credential cache of the exec config for the token of the vault server mock
+func testCredentialCache(t *testing.T, e *ExecConfig) *credentialCache {
+	client, err := vault.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache, err := newCredentialCache(client, e)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cache
+}
+
+func TestExecCredentialRenewExpiring(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	os.Setenv(ENV_VAULTPAL_KUBE_CACHE_DIR, t.TempDir())
+
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	expiringCert, expiringKey := newTestCertificate(t, "smurf", time.Now().Add(time.Minute))
+	err := testCredentialCache(t, credentialExecConfig("jim", "master", WriteOptions{})).write(&credentials{
+		Certificate: expiringCert,
+		PrivateKey:  expiringKey,
+		Expiration:  time.Now().Add(time.Minute),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	out, err := handleExecCredential("jim", "master", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := clientauthv1.ExecCredential{}
+	err = json.Unmarshal(out, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, cert, got.Status.ClientCertificateData)
+}
+
+func TestWriteKubeconfigExec(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	os.Setenv(ENV_VAULTPAL_KUBE_CACHE_DIR, t.TempDir())
+
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: CERT, privateKey: PRIVATE_KEY}).mockIssueCert
+
+	kubeC, _, err := renderKubeconfig([]byte{}, "jim", "master", WriteOptions{Exec: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, User{
+		Exec: &ExecConfig{
+			ApiVersion:      "client.authentication.k8s.io/v1",
+			Command:         "vaultpal",
+			Args:            []string{"kube", "credential", "jim", "master"},
+			InteractiveMode: "Never",
+		},
+	}, got.Users[0].User)
+	assert.Equal(t, StringToBase64String(CA), got.Clusters[0].Cluster.CertificateAuthorityData)
+
+	cached, err := testCredentialCache(t, got.Users[0].User.Exec).read()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, CERT, cached.Certificate)
+}
@@ -0,0 +1,28 @@
+package kube
+
+import (
+	"fmt"
+)
+
+// ClusterNotFoundError is returned, if the cluster registry contains no definition of Cluster
+type ClusterNotFoundError struct {
+	Cluster string
+}
+
+func (e *ClusterNotFoundError) Error() string {
+	return fmt.Sprintf("cluster %s is undefined", e.Cluster)
+}
+
+// IssueError is returned, if vault fails to issue credentials at Path, e.g. k8s-pki/issue/master
+type IssueError struct {
+	Path string
+	Err  error
+}
+
+func (e *IssueError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *IssueError) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,36 @@
+package kube
+
+import (
+	"net/http"
+	"os"
+	"testing"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteKubeconfigTypedErrors(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"nope"] = (&u.MockErrorData{Errors: &[]string{}, HTTPStatus: http.StatusNotFound}).MockErrorResponse
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&u.MockErrorData{Errors: &[]string{"permission denied"}, HTTPStatus: http.StatusForbidden}).MockErrorResponse
+
+	_, _, err := renderKubeconfig([]byte{}, "nope", "master", WriteOptions{})
+	var notFound *ClusterNotFoundError
+	if assert.ErrorAs(t, err, &notFound) {
+		assert.Equal(t, "nope", notFound.Cluster)
+	}
+
+	_, _, err = renderKubeconfig([]byte{}, "jim", "master", WriteOptions{})
+	var issue *IssueError
+	if assert.ErrorAs(t, err, &issue) {
+		assert.Equal(t, "k8s-pki/issue/master", issue.Path)
+	}
+	assert.Contains(t, err.Error(), "error creating client key for cluster")
+}
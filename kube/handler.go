@@ -2,229 +2,464 @@ package kube
 
 import (
 	"github.com/dbschenker/vaultpal/config"
+	"github.com/dbschenker/vaultpal/utils"
 	"github.com/dbschenker/vaultpal/vault"
 	"github.com/hashicorp/vault/api"
 	"github.com/mitchellh/go-homedir"
-	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v2"
 	"io"
 	"os"
-	"strings"
+	"time"
 )
 
 const ENV_VAULTPAL_KUBECONFIG_FILE = "VAULTPAL_KUBECONFIG_FILE"
 
-func contextEntryMap(c []ContextEntry) map[string]ContextEntry {
-	cm := map[string]ContextEntry{}
+// upsertClusterEntry replaces the cluster entry with the same name in place or appends it.
+// Fields unknown to vaultpal are taken over from the replaced entry, except those conflicting with the CA written by vaultpal.
+func upsertClusterEntry(entries []ClusterEntry, e ClusterEntry) []ClusterEntry {
+	for i, old := range entries {
+		if old.Name == e.Name {
+			e.Extra = old.Extra
+			e.Cluster.Extra = old.Cluster.Extra
+			if e.Cluster.CertificateAuthorityData != "" {
+				e.Cluster.Extra = withoutKeys(old.Cluster.Extra, "certificate-authority", "insecure-skip-tls-verify")
+			}
+			e.Cluster.Extensions = mergeExtensions(old.Cluster.Extensions, e.Cluster.Extensions)
+			// connection settings added by the user are kept, unless the registry defines them
+			if e.Cluster.ProxyURL == "" {
+				e.Cluster.ProxyURL = old.Cluster.ProxyURL
+			}
+			if e.Cluster.TLSServerName == "" {
+				e.Cluster.TLSServerName = old.Cluster.TLSServerName
+			}
+			entries[i] = e
+			return entries
+		}
+	}
+	return append(entries, e)
+}
 
-	for _, ce := range c {
-		cm[ce.Name] = ce
+// upsertContextEntry replaces the context entry with the same name in place or appends it.
+// Fields unknown to vaultpal are taken over from the replaced entry.
+func upsertContextEntry(entries []ContextEntry, e ContextEntry) []ContextEntry {
+	for i, old := range entries {
+		if old.Name == e.Name {
+			e.Extra = old.Extra
+			e.Context.Extra = old.Context.Extra
+			e.Context.Extensions = mergeExtensions(old.Context.Extensions, e.Context.Extensions)
+			entries[i] = e
+			return entries
+		}
 	}
+	return append(entries, e)
+}
 
-	return cm
+// upsertUserEntry replaces the user entry with the same name in place or appends it.
+// Fields unknown to vaultpal are taken over from the replaced entry, except the credential files conflicting
+// with the credentials written by vaultpal.
+func upsertUserEntry(entries []UserEntry, e UserEntry) []UserEntry {
+	for i, old := range entries {
+		if old.Name == e.Name {
+			e.Extra = old.Extra
+			e.User.Extra = withoutKeys(old.User.Extra, "client-certificate", "client-key", "tokenFile")
+			entries[i] = e
+			return entries
+		}
+	}
+	return append(entries, e)
 }
 
-func contextEntrySlice(m map[string]ContextEntry) []ContextEntry {
-	sl := make([]ContextEntry, len(m))
-	idx := 0
+func getPalKubeConfig(reg *registry, cluster string) (config.KubeCluster, error) {
+	k8s, err := reg.read(cluster)
+	if err != nil {
+		return config.KubeCluster{}, err
+	}
 
-	for _, v := range m {
-		sl[idx] = v
-		idx++
+	if k8s == nil {
+		return config.KubeCluster{}, &ClusterNotFoundError{Cluster: cluster}
 	}
 
-	return sl
+	if k8s.Name == "" {
+		log.Errorf("Vaultpath %s exists but contains no config for cluster %s", reg.clusterPath(cluster), cluster)
+	}
+	return *k8s, nil
 }
 
-func clusterEntryMap(c []ClusterEntry) map[string]ClusterEntry {
-	cm := map[string]ClusterEntry{}
+// renderKubeconfig returns the kubeconfig with the new entries of cluster and role, and with opts.Revoke
+// the credentials replaced by them, which are to be revoked once the kubeconfig is stored
+func renderKubeconfig(kconfig []byte, cluster string, role string, opts WriteOptions) ([]byte, *replacedCredentials, error) {
+	client, err := vault.NewClient()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating vault api client")
+	}
 
-	for _, ce := range c {
-		cm[ce.Name] = ce
+	user, err := lookupIdentity(client)
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return cm
-}
+	reg, err := newRegistry(client)
+	if err != nil {
+		return nil, nil, err
+	}
 
-func clusterEntrySlice(m map[string]ClusterEntry) []ClusterEntry {
-	sl := make([]ClusterEntry, len(m))
-	idx := 0
+	// everything but the entries owned by vaultpal is kept as it is
+	k8, err := parseKubeConfig(kconfig)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	for _, v := range m {
-		sl[idx] = v
-		idx++
+	// the cached credentials of a replaced exec user are read before the cache is overwritten by the new ones
+	var replacedCaches []*replacedCache
+	if opts.Revoke {
+		replacedCaches, err = readReplacedCache(client, k8, cluster)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	return sl
-}
+	entries, err := renderEntries(reg, user, cluster, role, opts)
+	if err != nil {
+		return nil, nil, err
+	}
 
-func userEntryMap(u []UserEntry) map[string]UserEntry {
-	um := map[string]UserEntry{}
+	prefix := ""
+	if opts.Target == TargetKubeconfig {
+		prefix = managedPrefix
+		prefixEntries(entries)
+	}
 
-	for _, ue := range u {
-		um[ue.Name] = ue
+	var replaced *replacedCredentials
+	if opts.Revoke {
+		replaced = newReplacedCredentials(reg, k8, entries, replacedCaches)
 	}
 
-	return um
-}
+	mergeEntries(k8, entries)
+	if !opts.NoSwitch {
+		k8.CurrentContext = entries.Context.Name
+	}
 
-func userEntrySlice(m map[string]UserEntry) []UserEntry {
-	sl := make([]UserEntry, len(m))
-	idx := 0
+	if opts.Prune {
+		pruned, err := pruneConfig(k8, reg, prefix)
+		if err != nil {
+			return nil, nil, err
+		}
+		logPruned(pruned)
+	}
 
-	for _, v := range m {
-		sl[idx] = v
-		idx++
+	out, err := yaml.Marshal(k8)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot marshal kubeconfig file")
 	}
 
-	return sl
+	log.WithFields(log.Fields{
+		"Cluster":    entries.Cluster.Name,
+		"API":        entries.Cluster.Cluster.Server,
+		"Role":       role,
+		"Namespace":  entries.Context.Context.Namespace,
+		"Expiration": entries.Expiration.Local().Format(time.RFC3339),
+	}).Info("Let's kube 🛀")
+
+	return out, replaced, nil
 }
 
-func getPalKubeConfig(client *api.Client, cluster string) config.KubeCluster {
-	vaultpath := "kv/data/vaultbro/k8s/clusters/" + cluster
-	k8s, err := client.Logical().Read(vaultpath)
-	if err != nil {
-		log.Fatal("error reading vaultpal config entry for cluster:  " + err.Error())
-	}
+// kubeEntries are the kubeconfig entries vaultpal owns for a cluster
+type kubeEntries struct {
+	Cluster ClusterEntry
+	Context ContextEntry
+	User    UserEntry
+	// Expiration of the issued credentials
+	Expiration time.Time
+}
 
-	if k8s == nil {
-		log.Fatalf("Cluster %s is undefined", cluster)
+// renderEntries issues credentials for identity with the role and renders the kubeconfig entries of the cluster
+func renderEntries(reg *registry, identity string, cluster string, role string, opts WriteOptions) (*kubeEntries, error) {
+	cf, issuer, err := resolveCluster(reg, cluster)
+	if err != nil {
+		return nil, err
 	}
 
-	cf := config.KubeCluster{}
-	mapstructure.Decode(k8s.Data["data"], &cf)
-	if cf.Name == "" {
-		log.Errorf("Vaultpath %s exists but contains no config for cluster %s", vaultpath, cluster)
+	namespace, err := resolveNamespace(role, opts.Namespace, cf, issuer)
+	if err != nil {
+		return nil, err
 	}
-	return cf
-}
-
-func handleWriteKubeconfig(kconfig []byte, cluster string, role string) ([]byte, error) {
-	namespace := deriveNamespaceFromRole(role)
 	log.WithFields(log.Fields{
 		"Cluster":   cluster,
 		"Role":      role,
 		"Namespace": namespace,
 	}).Info("write a kubeconfig for")
-	client, err := vault.NewClient()
+
+	userName := cf.Name + "_" + identity
+
+	creds, err := issueClusterCredentials(reg.client, cf, issuer, role, identity, namespace, opts)
 	if err != nil {
-		log.Fatal("error creating vault api client: " + err.Error())
+		return nil, err
 	}
 
+	clusterE := ClusterEntry{
+		Name: cf.Name,
+		Cluster: Cluster{
+			Server:                   cf.Server,
+			CertificateAuthorityData: StringToBase64String(clusterCA(cf, issuer, creds)),
+			ProxyURL:                 cf.ProxyURL,
+			TLSServerName:            cf.TLSServerName,
+		},
+	}
+	clusterE.Cluster.SetLabels(clusterLabels(cf, issuer))
+	contextE := ContextEntry{
+		Name: cf.Name,
+		Context: Context{
+			Cluster:   cf.Name,
+			Namespace: namespace,
+			User:      userName,
+		},
+	}
+	metadata := Metadata{
+		Cluster:            cluster,
+		Role:               role,
+		Namespace:          opts.Namespace,
+		KeyType:            opts.KeyType,
+		KeyBits:            opts.KeyBits,
+		AltNames:           opts.AltNames,
+		Sign:               opts.Sign,
+		ClusterRoleBinding: opts.ClusterRoleBinding,
+	}
+	if opts.TTL != 0 {
+		metadata.TTL = opts.TTL.String()
+	}
+	if !opts.Exec {
+		// the serial of exec users is kept in the credential cache
+		metadata.Serial = creds.Serial
+	}
+	contextE.Context.SetMetadata(metadata)
+	userE := UserEntry{
+		Name: userName,
+	}
+	if opts.Exec {
+		userE.User = User{
+			Exec: credentialExecConfig(cluster, role, opts),
+		}
+		// the issued credentials seed the cache of the exec plugin, so kubectl can start right away
+		cache, err := newCredentialCache(reg.client, userE.User.Exec)
+		if err == nil {
+			err = cache.write(creds)
+		}
+		if err != nil {
+			log.WithError(err).Warn("cannot cache issued credentials")
+		}
+	} else if creds.Token != "" {
+		userE.User = User{
+			Token: creds.Token,
+		}
+	} else {
+		userE.User = User{
+			ClientCertificateData: StringToBase64String(creds.Certificate),
+			ClientKeyData:         StringToBase64String(creds.PrivateKey),
+		}
+	}
+
+	return &kubeEntries{
+		Cluster:    clusterE,
+		Context:    contextE,
+		User:       userE,
+		Expiration: creds.Expiration,
+	}, nil
+}
+
+// mergeEntries replaces or adds the entries owned by vaultpal in the kubeconfig
+func mergeEntries(k8 *Config, e *kubeEntries) {
+	k8.ApiVersion = "v1"
+	k8.Kind = "Config"
+	k8.Clusters = upsertClusterEntry(k8.Clusters, e.Cluster)
+	k8.Contexts = upsertContextEntry(k8.Contexts, e.Context)
+	k8.Users = upsertUserEntry(k8.Users, e.User)
+}
+
+// lookupIdentity returns the display name of the current vault token, which is used as common name of the client certificates
+func lookupIdentity(client *api.Client) (string, error) {
 	user, err := vault.GetIdentityName(client)
 	if err != nil {
-		return nil, errors.Wrap(err, "error getting own identity")
+		return "", errors.Wrap(err, "error getting own identity")
 	} else if user == nil || *user == "" {
-		return nil, errors.New("identity must be not empty/nil")
+		return "", errors.New("identity must be not empty/nil")
 	}
 
 	log.WithFields(log.Fields{
 		"identity": *user,
 	}).Info("got your identity")
 
-	cf := getPalKubeConfig(client, cluster)
+	return *user, nil
+}
 
-	err = verifyPalKubeConfig(cf)
+// resolveCluster reads the vaultpal definition of a cluster and returns it together with the definition
+// of the cluster issuing its credentials. If the cluster is an alias, this is the end of the alias chain.
+func resolveCluster(reg *registry, cluster string) (config.KubeCluster, config.KubeCluster, error) {
+	chain, path, err := resolveAliasChain(reg, cluster)
 	if err != nil {
-		return nil, err
+		if len(chain) == 0 {
+			return config.KubeCluster{}, config.KubeCluster{}, err
+		}
+		return chain[0], chain[0], err
 	}
+	cf := chain[0]
 
 	log.WithFields(log.Fields{
-		"Cluster":   cf.Name,
-		"PKI":       cf.PKI,
-		"APIServer": cf.Server,
-		"Alias":     cf.Alias,
+		"Cluster":    cf.Name,
+		"PKI":        cf.PKI,
+		"Kubernetes": cf.Kubernetes,
+		"APIServer":  cf.Server,
+		"Alias":      cf.Alias,
 	}).Info("using k8s definition")
 
-	pki := cf.PKI
+	issuer := chain[len(chain)-1]
 	if cf.Alias != "" {
-		cfAlias := getPalKubeConfig(client, cf.Alias)
-		err = verifyPalKubeConfig(cfAlias)
-		if err != nil {
-			return nil, err
-		}
-		pki = cfAlias.PKI
 		log.WithFields(log.Fields{
-			"Alias": cf.Alias,
-			"PKI":   cfAlias.PKI,
-		}).Infof("[%s] is an alias pointing to [%s]", cf.Name, cfAlias.Name)
+			"Alias":      cf.Alias,
+			"Path":       formatAliasPath(path),
+			"PKI":        issuer.PKI,
+			"Kubernetes": issuer.Kubernetes,
+		}).Infof("[%s] is an alias pointing to [%s]", cf.Name, issuer.Name)
 	}
 
-	userName := cf.Name + "_" + *user
+	return cf, issuer, nil
+}
 
-	secret, err := client.Logical().Write(pki+"/issue/"+role, map[string]interface{}{
-		"common_name": *user,
-		"ttl":         "3600",
-	})
-	if err != nil {
-		log.Fatal("error creating client key for cluster: " + err.Error())
+// clusterLabels returns the labels of the issuing cluster, overridden by the labels of an alias
+func clusterLabels(cf config.KubeCluster, issuer config.KubeCluster) map[string]string {
+	if len(cf.Labels) == 0 && len(issuer.Labels) == 0 {
+		return nil
+	}
+	labels := map[string]string{}
+	for k, v := range issuer.Labels {
+		labels[k] = v
+	}
+	for k, v := range cf.Labels {
+		labels[k] = v
 	}
+	return labels
+}
 
-	clusterE := ClusterEntry{
-		Name: cf.Name,
-		Cluster: Cluster{
-			Server:                   cf.Server,
-			CertificateAuthorityData: StringToBase64String(secret.Data["issuing_ca"].(string)),
-		},
+// issueClusterCredentials issues credentials for the identity with the given role, depending on the auth mode of the issuing cluster
+func issueClusterCredentials(client *api.Client, cf config.KubeCluster, issuer config.KubeCluster, role string, identity string, namespace string, opts WriteOptions) (*credentials, error) {
+	req, err := newCredentialRequest(cf, issuer, opts)
+	if err != nil {
+		return nil, err
 	}
-	contextE := ContextEntry{
-		Name: cf.Name,
-		Context: Context{
-			Cluster:   cf.Name,
-			Namespace: namespace,
-			User:      userName,
-		},
+
+	var creds *credentials
+	switch {
+	case issuer.AuthMode() == config.KubeAuthToken:
+		if req.Sign {
+			return nil, errors.Errorf("cluster %s uses service account tokens, which cannot be signed", cf.Name)
+		}
+		creds, err = issueToken(client, issuer.Kubernetes, role, namespace, opts.ClusterRoleBinding, req)
+	case req.Sign || req.KeyType != "":
+		// the key of the issue endpoint is generated by the pki role, a requested key type is generated locally
+		creds, err = signCredentials(client, issuer.PKI, role, identity, req)
+	default:
+		creds, err = issueCredentials(client, issuer.PKI, role, identity, req)
 	}
-	userE := UserEntry{
-		Name: userName,
-		User: User{
-			ClientCertificateData: StringToBase64String(secret.Data["certificate"].(string)),
-			ClientKeyData:         StringToBase64String(secret.Data["private_key"].(string)),
-		},
+	if err != nil {
+		return nil, err
 	}
 
-	k8 := Config{
-		ApiVersion:     "v1",
-		Kind:           "Config",
-		CurrentContext: cf.Name,
-		Clusters:       []ClusterEntry{},
-		Contexts:       []ContextEntry{},
-		Users:          []UserEntry{},
+	log.WithFields(log.Fields{
+		"Cluster":    cf.Name,
+		"Role":       role,
+		"Expiration": creds.Expiration.Format(time.RFC3339),
+	}).Debug("issued credentials")
+	return creds, nil
+}
+
+// issueToken creates a service account token with the given role of a kubernetes secrets engine
+func issueToken(client *api.Client, mount string, role string, namespace string, clusterRoleBinding bool, req credentialRequest) (*credentials, error) {
+	secret, err := client.Logical().Write(mount+"/creds/"+role, map[string]interface{}{
+		"kubernetes_namespace": namespace,
+		"cluster_role_binding": clusterRoleBinding,
+		"ttl":                  ttlSeconds(req.TTL),
+	})
+	if err != nil {
+		return nil, &IssueError{Path: mount + "/creds/" + role, Err: errors.Wrap(vault.CheckReachable(client, err), "error creating service account token for cluster")}
+	}
+	if secret == nil {
+		return nil, &IssueError{Path: mount + "/creds/" + role, Err: errors.Errorf("no service account token issued by [%s/creds/%s]", mount, role)}
 	}
 
-	oldk8, err := parseKubeConfig(kconfig)
+	creds := &credentials{}
+	creds.Token, err = vault.GetVerifiedSecretString(secret, "service_account_token", true)
 	if err != nil {
 		return nil, err
 	}
+	creds.Expiration = time.Now().Add(time.Duration(secret.LeaseDuration) * time.Second)
 
-	contextM := contextEntryMap(oldk8.Contexts)
-	contextM[contextE.Name] = contextE
-	clusterM := clusterEntryMap(oldk8.Clusters)
-	clusterM[clusterE.Name] = clusterE
-	userM := userEntryMap(oldk8.Users)
-	userM[userE.Name] = userE
-	k8.Contexts = contextEntrySlice(contextM)
-	k8.Clusters = clusterEntrySlice(clusterM)
-	k8.Users = userEntrySlice(userM)
+	log.WithFields(log.Fields{
+		"ServiceAccount": secret.Data["service_account_name"],
+		"Namespace":      secret.Data["service_account_namespace"],
+		"LeaseDuration":  secret.LeaseDuration,
+	}).Debug("issued service account token")
 
-	out, err := yaml.Marshal(k8)
+	return creds, nil
+}
+
+// clusterCA returns the certificate authority of the api server. The CA configured in the registry takes
+// precedence over the CA of the pki issuing the client certificates.
+func clusterCA(cf config.KubeCluster, issuer config.KubeCluster, creds *credentials) string {
+	if cf.CA != "" {
+		return cf.CA
+	}
+	if issuer.CA != "" {
+		return issuer.CA
+	}
+	return creds.IssuingCA
+}
+
+// issueCredentials creates a client certificate and key for the identity with the given pki role
+func issueCredentials(client *api.Client, pki string, role string, identity string, req credentialRequest) (*credentials, error) {
+	data := map[string]interface{}{
+		"common_name": identity,
+		"ttl":         ttlSeconds(req.TTL),
+	}
+	if len(req.AltNames) > 0 {
+		data["alt_names"] = joinAltNames(req.AltNames)
+	}
+	secret, err := client.Logical().Write(pki+"/issue/"+role, data)
 	if err != nil {
-		return nil, errors.Wrap(err, "cannot marshal kubeconfig file")
+		return nil, &IssueError{Path: pki + "/issue/" + role, Err: errors.Wrap(vault.CheckReachable(client, err), "error creating client key for cluster")}
+	}
+	if secret == nil {
+		return nil, &IssueError{Path: pki + "/issue/" + role, Err: errors.Errorf("no client key issued by [%s/issue/%s]", pki, role)}
 	}
 
-	log.WithFields(log.Fields{
-		"Cluster": cf.Name,
-		"API":     cf.Server,
-		"Role":    role,
-	}).Info("Let's kube 🛀")
+	creds := &credentials{}
+	creds.Certificate, err = vault.GetVerifiedSecretString(secret, "certificate", true)
+	if err != nil {
+		return nil, err
+	}
+	creds.PrivateKey, err = vault.GetVerifiedSecretString(secret, "private_key", true)
+	if err != nil {
+		return nil, err
+	}
+	creds.IssuingCA, err = vault.GetVerifiedSecretString(secret, "issuing_ca", true)
+	if err != nil {
+		return nil, err
+	}
+	creds.Serial, _ = vault.GetVerifiedSecretString(secret, "serial_number", false)
+	creds.Expiration, err = issuedExpiration(secret, creds.Certificate)
+	if err != nil {
+		return nil, err
+	}
 
-	return out, nil
+	return creds, nil
 }
 
 func verifyPalKubeConfig(cluster config.KubeCluster) error {
 
+	if cluster.SchemaVersion > config.KubeSchemaVersion {
+		return errors.Errorf("unsupported schema version %d of cluster %s, vaultpal supports up to version %d, please upgrade vaultpal",
+			cluster.SchemaVersion, cluster.Name, config.KubeSchemaVersion)
+	}
 	if cluster.Name == "" {
 		return errors.New("cluster name must not be empty")
 	}
@@ -232,13 +467,25 @@ func verifyPalKubeConfig(cluster config.KubeCluster) error {
 		return errors.New("server must not be empty")
 	}
 	if cluster.Alias == "" {
-		if cluster.PKI == "" {
-			return errors.New("pki must not be empty")
+		switch cluster.AuthMode() {
+		case config.KubeAuthCertificate:
+			if cluster.PKI == "" {
+				return errors.New("pki must not be empty")
+			}
+		case config.KubeAuthToken:
+			if cluster.Kubernetes == "" {
+				return errors.New("kubernetes must not be empty")
+			}
+		default:
+			return errors.Errorf("unsupported auth [%s], must be one of [%s %s]", cluster.Auth, config.KubeAuthCertificate, config.KubeAuthToken)
 		}
 	} else {
 		if cluster.PKI != "" {
 			return errors.New("pki must be empty")
 		}
+		if cluster.Kubernetes != "" {
+			return errors.New("kubernetes must be empty")
+		}
 	}
 	return nil
 }
@@ -258,7 +505,7 @@ func ensurePalKubeConfigFile() (string, error) {
 		// Make sure pal kube dir exists
 		err = os.MkdirAll(kubeconfigPath, 0740)
 		if err != nil {
-			log.WithError(err).Fatal("cannot create dir")
+			return "", errors.Wrapf(err, "cannot create dir [%s]", kubeconfigPath)
 		}
 	} else {
 		kubeconfigFile = envPalKFile
@@ -267,44 +514,130 @@ func ensurePalKubeConfigFile() (string, error) {
 	return kubeconfigFile, nil
 }
 
-func WriteKubeconfig(cluster string, role string) error {
+// WriteOptions controls how the credentials of a cluster are rendered into the kubeconfig
+type WriteOptions struct {
+	// Exec writes an exec credential plugin stanza calling vaultpal instead of static client certificate data
+	Exec bool
+	// Prune removes stale entries from the kubeconfig, see Prune
+	Prune bool
+	// Target is the kubeconfig file to merge the entries into, one of Targets
+	Target string
+	// ClusterRoleBinding requests a cluster wide role binding for service account tokens of the kubernetes secrets engine
+	ClusterRoleBinding bool
+	// Namespace of the context, derived from the role if empty
+	Namespace string
+	// TTL of the issued credentials, the default of the cluster if zero
+	TTL time.Duration
+	// KeyType of the client key, one of KeyTypes. Verified against the key issued by the pki role.
+	KeyType string
+	// KeyBits of the client key, verified along with KeyType
+	KeyBits int
+	// AltNames are additional subject alternative names of the client certificate
+	AltNames []string
+	// Sign generates the client key locally and sends a certificate signing request to pki/sign instead of pki/issue
+	Sign bool
+	// Revoke revokes the client certificate of the replaced context
+	Revoke bool
+	// Check verifies the written context against the api server, see Check
+	Check bool
+	// Split writes the context into a kubeconfig file of its own instead of Target, one of Splits
+	Split string
+	// NoSwitch keeps the current context of the kubeconfig
+	NoSwitch bool
+}
+
+func WriteKubeconfig(cluster string, role string, opts WriteOptions) error {
 
-	kubeconfigFile, err := ensurePalKubeConfigFile()
+	kubeconfigFile, err := writeKubeConfigFile(cluster, role, opts)
 	if err != nil {
 		return err
 	}
 
-	err = createPalKubeConfigFile(kubeconfigFile)
+	var newKubeConfig []byte
+	var replaced *replacedCredentials
+	err = updateKubeConfig(opts.Target, kubeconfigFile, func(kconfig []byte) ([]byte, error) {
+		newKubeConfig, replaced, err = renderKubeconfig(kconfig, cluster, role, opts)
+		return newKubeConfig, err
+	})
 	if err != nil {
 		return err
 	}
+	// the replaced credentials are revoked only after the new ones are stored
+	if replaced != nil {
+		replaced.revoke()
+	}
 
-	kubeConfigR, err := kubeConfigReader(kubeconfigFile)
-	if err != nil {
-		kubeConfigR.Close()
-		return err
+	switch {
+	case opts.Split != "":
+		log.Infof("Wrote kubeconfig to %s", kubeconfigFile)
+	case opts.Target == TargetKubeconfig:
+		log.Infof("Merged kubeconfig into %s", kubeconfigFile)
+	default:
+		log.Infof("Enable kubeconfig with: KUBECONFIG=%s", kubeconfigFile)
 	}
 
-	kubeConfigRaw, err := readKubeConfigRaw(kubeConfigR)
+	if opts.Check {
+		contextName, err := clusterContext(newKubeConfig, cluster)
+		if err != nil {
+			return err
+		}
+		// in split mode stdout carries the KUBECONFIG export only
+		out := io.Writer(os.Stdout)
+		if opts.Split != "" {
+			out = os.Stderr
+		}
+		err = runCheck(out, newKubeConfig, contextName, utils.OutputTable)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.Split != "" {
+		exportCmd, err := exportKubeConfig(kubeconfigFile)
+		if err != nil {
+			return err
+		}
+		_, _ = os.Stdout.Write([]byte(exportCmd + "\n"))
+	}
+
+	return nil
+}
+
+// writeKubeConfigFile returns the location of the kubeconfig file the context of cluster and role is written to
+func writeKubeConfigFile(cluster string, role string, opts WriteOptions) (string, error) {
+	if opts.Split == "" {
+		return targetKubeConfigFile(opts.Target)
+	}
+	if opts.Target == TargetKubeconfig {
+		return "", errors.Errorf("cannot split contexts into files of their own with target [%s]", TargetKubeconfig)
+	}
+	return splitKubeConfigFile(opts.Split, cluster, role)
+}
+
+// readPalKubeConfig returns the location and content of the vaultpal kubeconfig file, which is created if it does not exist
+func readPalKubeConfig() (string, []byte, error) {
+	kubeconfigFile, err := ensurePalKubeConfigFile()
 	if err != nil {
-		kubeConfigR.Close()
-		return err
+		return "", nil, err
 	}
-	kubeConfigR.Close()
 
-	newKubeConfig, err := handleWriteKubeconfig(kubeConfigRaw, cluster, role)
+	err = createPalKubeConfigFile(kubeconfigFile)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
-	err = os.WriteFile(kubeconfigFile, newKubeConfig, 0600)
+	kubeConfigR, err := kubeConfigReader(kubeconfigFile)
 	if err != nil {
-		return errors.Wrapf(err, "cannot write kubeconfig to [%s]", kubeconfigFile)
+		return "", nil, err
 	}
+	defer kubeConfigR.Close()
 
-	log.Infof("Enable kubeconfig with: KUBECONFIG=%s", kubeconfigFile)
+	kubeConfigRaw, err := readKubeConfigRaw(kubeConfigR)
+	if err != nil {
+		return "", nil, err
+	}
 
-	return nil
+	return kubeconfigFile, kubeConfigRaw, nil
 }
 
 func createPalKubeConfigFile(file string) error {
@@ -353,12 +686,9 @@ func parseKubeConfig(configRaw []byte) (*Config, error) {
 // However with the advent of different roles types for the same topic/environment such as ttb-int-user
 // We need to strip those suffixes to ensure that the default kubeconfig points to a namespace that actually exists
 // Similar to -user we may introduce -admin and additional more fine grained suffixed in future
+// Additional rules can be defined per cluster in the registry and in the local vaultpal config, see resolveNamespace
 func deriveNamespaceFromRole(role string) (namespace string) {
-	roleSuffixes := [...]string{"-user", "-admin"} // also support -admin, even though it's currently not used
-	for _, suffix := range roleSuffixes {
-		if strings.HasSuffix(role, suffix) {
-			return role[0 : len(role)-len(suffix)]
-		}
-	}
-	return role // traditional behaviour (role == namespace): if no known suffix matches, just return the role input
+	// the default rules are valid, so there is no error
+	namespace, _ = deriveNamespace(role, defaultNamespaceRules)
+	return namespace // traditional behaviour (role == namespace): if no known suffix matches, just return the role input
 }
@@ -5,16 +5,19 @@ import (
 	u "github.com/dbschenker/vaultpal/internal/testutil"
 	"github.com/hashicorp/vault/api"
 	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
 	cgt "k8s.io/client-go/util/testing"
 	"net/http"
 	"os"
 	"sort"
 	"testing"
+	"time"
 )
 
 const (
 	PATH_LOOKUP_SELF     = "/v1/auth/token/lookup-self"
 	PATH_BRO_CONFIG_BASE = "/v1/kv/data/vaultbro/k8s/clusters/"
+	PATH_KV_MOUNT        = "/v1/sys/internal/ui/mounts/kv"
 	PATH_ISSUE_CERT_F    = "/v1/%s/issue/%s"
 
 	CA = `-----BEGIN CERTIFICATE-----
@@ -95,6 +98,13 @@ type mockData struct {
 	issuingCa   string
 	cert        string
 	privateKey  string
+	kvVersion   string
+	auth        string
+	kubernetes  string
+	ca          string
+	credentials map[string]interface{}
+	// extra are further fields of the cluster definition
+	extra map[string]interface{}
 }
 
 func issueCertPath(pki string, role string) string {
@@ -109,6 +119,18 @@ func (m *mockData) mockTokenLookupSelf(t *testing.T, w http.ResponseWriter, r *h
 	u.WriteJsonResponse(t, sec, w)
 }
 
+func (m *mockData) mockKVMount(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	sec := api.Secret{Data: map[string]interface{}{
+		"path": "kv/",
+		"type": "kv",
+		"options": map[string]interface{}{
+			"version": m.kvVersion,
+		},
+	}}
+	u.WriteJsonResponse(t, sec, w)
+}
+
 func (m *mockData) mockReadPalConfig(t *testing.T, w http.ResponseWriter, r *http.Request) {
 	var sec api.Secret
 	w.Header().Set("Content-Type", "application/json")
@@ -126,12 +148,24 @@ func (m *mockData) mockReadPalConfig(t *testing.T, w http.ResponseWriter, r *htt
 			"data": cdata,
 		}}
 	} else {
+		cdata := map[string]interface{}{
+			"name":   m.clusterName,
+			"pki":    m.pkiName,
+			"server": m.serverURL,
+		}
+		if m.auth != "" {
+			cdata["auth"] = m.auth
+			cdata["kubernetes"] = m.kubernetes
+			cdata["ca"] = m.ca
+		}
+		if m.credentials != nil {
+			cdata["credentials"] = m.credentials
+		}
+		for k, v := range m.extra {
+			cdata[k] = v
+		}
 		sec = api.Secret{Data: map[string]interface{}{
-			"data": map[string]interface{}{
-				"name":   m.clusterName,
-				"pki":    m.pkiName,
-				"server": m.serverURL,
-			},
+			"data": cdata,
 		}}
 	}
 	u.WriteJsonResponse(t, sec, w)
@@ -165,14 +199,34 @@ func TestWriteKubeconfigVerify(t *testing.T) {
 			Name: "Missing Cluster Name",
 			serveMocks: map[string]u.ServeMockFunc{
 				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
 				PATH_BRO_CONFIG_BASE + "jim": (&mockData{clusterName: "", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig,
 			},
 			WantErr: "cluster name must not be empty",
 		},
+		{
+			Name: "Token Missing Kubernetes Mount",
+			serveMocks: map[string]u.ServeMockFunc{
+				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
+				PATH_BRO_CONFIG_BASE + "jim": (&mockData{clusterName: "jim", serverURL: "jim-knopf.tsc.sh", auth: "token"}).mockReadPalConfig,
+			},
+			WantErr: "kubernetes must not be empty",
+		},
+		{
+			Name: "Unsupported Auth",
+			serveMocks: map[string]u.ServeMockFunc{
+				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
+				PATH_BRO_CONFIG_BASE + "jim": (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh", auth: "oidc"}).mockReadPalConfig,
+			},
+			WantErr: "unsupported auth [oidc], must be one of [certificate token]",
+		},
 		{
 			Name: "Missing Cluster Name - Nil",
 			serveMocks: map[string]u.ServeMockFunc{
 				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
 				PATH_BRO_CONFIG_BASE + "jim": (&mockData{pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig,
 			},
 			WantErr: "cluster name must not be empty",
@@ -181,6 +235,7 @@ func TestWriteKubeconfigVerify(t *testing.T) {
 			Name: "Missing PKI Name",
 			serveMocks: map[string]u.ServeMockFunc{
 				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
 				PATH_BRO_CONFIG_BASE + "jim": (&mockData{clusterName: "jim", pkiName: "", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig,
 			},
 			WantErr: "pki must not be empty",
@@ -189,6 +244,7 @@ func TestWriteKubeconfigVerify(t *testing.T) {
 			Name: "Missing PKI Name - Nil",
 			serveMocks: map[string]u.ServeMockFunc{
 				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
 				PATH_BRO_CONFIG_BASE + "jim": (&mockData{clusterName: "jim", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig,
 			},
 			WantErr: "pki must not be empty",
@@ -197,6 +253,7 @@ func TestWriteKubeconfigVerify(t *testing.T) {
 			Name: "Missing Server URL",
 			serveMocks: map[string]u.ServeMockFunc{
 				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
 				PATH_BRO_CONFIG_BASE + "jim": (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: ""}).mockReadPalConfig,
 			},
 			WantErr: "server must not be empty",
@@ -205,6 +262,7 @@ func TestWriteKubeconfigVerify(t *testing.T) {
 			Name: "Missing Server URL - Nil",
 			serveMocks: map[string]u.ServeMockFunc{
 				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
 				PATH_BRO_CONFIG_BASE + "jim": (&mockData{clusterName: "jim", pkiName: "k8s-pki"}).mockReadPalConfig,
 			},
 			WantErr: "server must not be empty",
@@ -213,6 +271,7 @@ func TestWriteKubeconfigVerify(t *testing.T) {
 			Name: "Alias Missing Server URL",
 			serveMocks: map[string]u.ServeMockFunc{
 				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
 				PATH_BRO_CONFIG_BASE + "jim": (&mockData{clusterName: "jim", aliasName: "knopf", serverURL: ""}).mockReadPalConfig,
 			},
 			WantErr: "server must not be empty",
@@ -221,6 +280,7 @@ func TestWriteKubeconfigVerify(t *testing.T) {
 			Name: "Alias Missing Server URL - nil",
 			serveMocks: map[string]u.ServeMockFunc{
 				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
 				PATH_BRO_CONFIG_BASE + "jim": (&mockData{clusterName: "jim", aliasName: "knopf"}).mockReadPalConfig,
 			},
 			WantErr: "server must not be empty",
@@ -229,6 +289,7 @@ func TestWriteKubeconfigVerify(t *testing.T) {
 			Name: "Alias No PKI expected",
 			serveMocks: map[string]u.ServeMockFunc{
 				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
 				PATH_BRO_CONFIG_BASE + "jim": (&mockData{clusterName: "jim", aliasName: "knopf", serverURL: "jim-knopf.tsc.sh", pkiName: "jim-pki"}).mockReadPalConfig,
 			},
 			WantErr: "pki must be empty",
@@ -237,11 +298,21 @@ func TestWriteKubeconfigVerify(t *testing.T) {
 			Name: "Alias Target Missing PKI",
 			serveMocks: map[string]u.ServeMockFunc{
 				PATH_LOOKUP_SELF:              (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                 (&mockData{kvVersion: "2"}).mockKVMount,
 				PATH_BRO_CONFIG_BASE + "jim":  (&mockData{clusterName: "jim", aliasName: "emma", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig,
 				PATH_BRO_CONFIG_BASE + "emma": (&mockData{clusterName: "emma", serverURL: "emma.tsc.sh"}).mockReadPalConfig,
 			},
 			WantErr: "pki must not be empty",
 		},
+		{
+			Name: "Unsupported Schema Version",
+			serveMocks: map[string]u.ServeMockFunc{
+				PATH_LOOKUP_SELF:             (&mockData{identity: "pingpong"}).mockTokenLookupSelf,
+				PATH_KV_MOUNT:                (&mockData{kvVersion: "2"}).mockKVMount,
+				PATH_BRO_CONFIG_BASE + "jim": (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh", extra: map[string]interface{}{"schema_version": 3}}).mockReadPalConfig,
+			},
+			WantErr: "unsupported schema version 3 of cluster jim, vaultpal supports up to version 2, please upgrade vaultpal",
+		},
 	}
 
 	vm := u.NewVaultServerMock(t)
@@ -251,7 +322,7 @@ func TestWriteKubeconfigVerify(t *testing.T) {
 
 	for _, test := range tests {
 		vm.ServeMocks = test.serveMocks
-		kubeC, err := handleWriteKubeconfig([]byte{}, "jim", "master")
+		kubeC, _, err := renderKubeconfig([]byte{}, "jim", "master", WriteOptions{})
 		assert.EqualError(t, err, test.WantErr)
 		assert.Nil(t, kubeC)
 	}
@@ -263,27 +334,68 @@ func TestWriteKubeconfigHTTPMock(t *testing.T) {
 	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
 	os.Setenv(api.EnvVaultToken, "1234")
 	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
 	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
 	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: CERT, privateKey: PRIVATE_KEY}).mockIssueCert
 
-	kubeC, err := handleWriteKubeconfig([]byte{}, "jim", "master")
+	kubeC, _, err := renderKubeconfig([]byte{}, "jim", "master", WriteOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	t.Log(string(kubeC))
 }
 
+func TestWriteKubeconfigClusterSettings(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh", extra: map[string]interface{}{
+		"schema_version":  2,
+		"proxy-url":       "socks5://bastion.tsc.sh:1080",
+		"tls-server-name": "api.jim.tsc.sh",
+		"labels":          map[string]string{"environment": "int", "team": "smurfs"},
+	}}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	kubeC, _, err := renderKubeconfig([]byte(FOREIGN_KUBECONFIG), "jim", "master", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, Cluster{
+		Server:                   "jim-knopf.tsc.sh",
+		CertificateAuthorityData: StringToBase64String(CA),
+		ProxyURL:                 "socks5://bastion.tsc.sh:1080",
+		TLSServerName:            "api.jim.tsc.sh",
+		Extensions: []NamedExtension{{
+			Name: "vaultpal",
+			Extension: map[string]interface{}{
+				"labels": map[interface{}]interface{}{"environment": "int", "team": "smurfs"},
+			},
+		}},
+	}, got.Clusters[1].Cluster)
+}
+
 func TestWriteKubeconfigAliasHTTPMock(t *testing.T) {
 	vm := u.NewVaultServerMock(t)
 	defer vm.CloseServer()
 	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
 	os.Setenv(api.EnvVaultToken, "1234")
 	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
 	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
 	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"lukas"] = (&mockData{clusterName: "lukas", serverURL: "lukas.tsc.sh", aliasName: "jim"}).mockReadPalConfig
 	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: CERT, privateKey: PRIVATE_KEY}).mockIssueCert
 
-	kubeC, err := handleWriteKubeconfig([]byte{}, "lukas", "master")
+	kubeC, _, err := renderKubeconfig([]byte{}, "lukas", "master", WriteOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -296,6 +408,7 @@ func TestWriteKubeconfigMultipleHTTPMock(t *testing.T) {
 	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
 	os.Setenv(api.EnvVaultToken, "1234")
 	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
 	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"emma"] = (&mockData{clusterName: "emma", pkiName: "k8s-pki-emma", serverURL: "emma.tsc.sh"}).mockReadPalConfig
 	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
 	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"lukas"] = (&mockData{clusterName: "lukas", serverURL: "lukas.tsc.sh", aliasName: "jim"}).mockReadPalConfig
@@ -316,9 +429,10 @@ func TestWriteKubeconfigMultipleHTTPMock(t *testing.T) {
 			{
 				Name: "lukas",
 				Context: Context{
-					Cluster:   "lukas",
-					Namespace: "master",
-					User:      "lukas_smurf",
+					Cluster:    "lukas",
+					Namespace:  "master",
+					User:       "lukas_smurf",
+					Extensions: metadataExtensions("lukas", "master"),
 				},
 			},
 		},
@@ -333,14 +447,14 @@ func TestWriteKubeconfigMultipleHTTPMock(t *testing.T) {
 		},
 		CurrentContext: "lukas",
 	}
-	kubeC, err := handleWriteKubeconfig([]byte{}, "lukas", "master")
+	kubeC, _, err := renderKubeconfig([]byte{}, "lukas", "master", WriteOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	t.Log(string(kubeC))
 	assertKubeConfig(t, expected, kubeC)
 
-	kubeC, err = handleWriteKubeconfig([]byte(kubeC), "emma", "lokomotive")
+	kubeC, _, err = renderKubeconfig([]byte(kubeC), "emma", "lokomotive", WriteOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -362,15 +476,16 @@ func TestWriteKubeconfigMultipleHTTPMock(t *testing.T) {
 	expected.Contexts = append(expected.Contexts, ContextEntry{
 		Name: "emma",
 		Context: Context{
-			Cluster:   "emma",
-			Namespace: "lokomotive",
-			User:      "emma_smurf",
+			Cluster:    "emma",
+			Namespace:  "lokomotive",
+			User:       "emma_smurf",
+			Extensions: metadataExtensions("emma", "lokomotive"),
 		},
 	})
 	expected.CurrentContext = "emma"
 	assertKubeConfig(t, expected, kubeC)
 
-	kubeC, err = handleWriteKubeconfig([]byte(kubeC), "jim", "master")
+	kubeC, _, err = renderKubeconfig([]byte(kubeC), "jim", "master", WriteOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -392,9 +507,10 @@ func TestWriteKubeconfigMultipleHTTPMock(t *testing.T) {
 	expected.Contexts = append(expected.Contexts, ContextEntry{
 		Name: "jim",
 		Context: Context{
-			Cluster:   "jim",
-			Namespace: "master",
-			User:      "jim_smurf",
+			Cluster:    "jim",
+			Namespace:  "master",
+			User:       "jim_smurf",
+			Extensions: metadataExtensions("jim", "master"),
 		},
 	})
 	expected.CurrentContext = "jim"
@@ -420,10 +536,11 @@ func TestWriteKubeconfigFile(t *testing.T) {
 	}
 
 	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
 	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
 	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: CERT, privateKey: PRIVATE_KEY}).mockIssueCert
 
-	err = WriteKubeconfig("jim", "master")
+	err = WriteKubeconfig("jim", "master", WriteOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -446,9 +563,10 @@ func TestWriteKubeconfigFile(t *testing.T) {
 			{
 				Name: "jim",
 				Context: Context{
-					Cluster:   "jim",
-					Namespace: "master",
-					User:      "jim_smurf",
+					Cluster:    "jim",
+					Namespace:  "master",
+					User:       "jim_smurf",
+					Extensions: metadataExtensions("jim", "master"),
 				},
 			},
 		},
@@ -466,7 +584,7 @@ func TestWriteKubeconfigFile(t *testing.T) {
 	assertKubeConfig(t, expected, cRaw)
 
 	// Run again - this will read file and should return same
-	err = WriteKubeconfig("jim", "master")
+	err = WriteKubeconfig("jim", "master", WriteOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -477,6 +595,124 @@ func TestWriteKubeconfigFile(t *testing.T) {
 	assertKubeConfig(t, expected, cRaw)
 }
 
+const FOREIGN_KUBECONFIG = `apiVersion: v1
+kind: Config
+preferences:
+  colors: true
+extensions:
+- name: tool
+  extension:
+    last-update: today
+clusters:
+- name: foreign
+  cluster:
+    server: https://foreign.tsc.sh
+    certificate-authority: /etc/foreign/ca.crt
+    proxy-url: socks5://localhost:1080
+    tls-server-name: api.foreign.tsc.sh
+- name: jim
+  cluster:
+    server: https://old.tsc.sh
+    certificate-authority-data: b2xk
+    proxy-url: socks5://localhost:1081
+contexts:
+- name: foreign
+  context:
+    cluster: foreign
+    user: foreign-user
+    extensions:
+    - name: tool
+      extension:
+        mood: happy
+users:
+- name: foreign-user
+  user:
+    token: abc
+    exec:
+      apiVersion: client.authentication.k8s.io/v1
+      command: aws
+      args:
+      - eks
+      - get-token
+      interactiveMode: IfAvailable
+      env:
+      - name: AWS_PROFILE
+        value: np
+current-context: foreign
+`
+
+func TestWriteKubeconfigPreservesUnknownFields(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: CERT, privateKey: PRIVATE_KEY}).mockIssueCert
+
+	kubeC, _, err := renderKubeconfig([]byte(FOREIGN_KUBECONFIG), "jim", "master", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(string(kubeC))
+
+	var before, after map[string]interface{}
+	if err := yaml.Unmarshal([]byte(FOREIGN_KUBECONFIG), &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := yaml.Unmarshal(kubeC, &after); err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, before["preferences"], after["preferences"])
+	assert.Equal(t, before["extensions"], after["extensions"])
+	assert.Equal(t, before["clusters"].([]interface{})[0], after["clusters"].([]interface{})[0])
+	assert.Equal(t, before["contexts"].([]interface{})[0], after["contexts"].([]interface{})[0])
+	assert.Equal(t, before["users"].([]interface{})[0], after["users"].([]interface{})[0])
+
+	got, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// entries keep their order, new entries are appended
+	assert.Equal(t, []string{"foreign", "jim"}, []string{got.Clusters[0].Name, got.Clusters[1].Name})
+	assert.Equal(t, []string{"foreign", "jim"}, []string{got.Contexts[0].Name, got.Contexts[1].Name})
+	assert.Equal(t, []string{"foreign-user", "jim_smurf"}, []string{got.Users[0].Name, got.Users[1].Name})
+	assert.Equal(t, "jim", got.CurrentContext)
+
+	// the vaultpal owned cluster is updated, but keeps fields unknown to vaultpal
+	assert.Equal(t, "jim-knopf.tsc.sh", got.Clusters[1].Cluster.Server)
+	assert.Equal(t, StringToBase64String(CA), got.Clusters[1].Cluster.CertificateAuthorityData)
+	assert.Equal(t, "socks5://localhost:1081", got.Clusters[1].Cluster.ProxyURL)
+
+	// writing again is stable
+	kubeC2, _, err := renderKubeconfig(kubeC, "jim", "master", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, string(kubeC), string(kubeC2))
+}
+
+func TestUpsertEntriesDropConflictingFiles(t *testing.T) {
+	clusters := []ClusterEntry{{Name: "jim", Cluster: Cluster{Server: "https://old.tsc.sh", Extra: map[string]interface{}{
+		"certificate-authority":    "/etc/jim/ca.crt",
+		"insecure-skip-tls-verify": true,
+		"disable-compression":      true,
+	}}}}
+	clusters = upsertClusterEntry(clusters, ClusterEntry{Name: "jim", Cluster: Cluster{Server: "https://jim.tsc.sh", CertificateAuthorityData: "Y2E="}})
+	assert.Equal(t, map[string]interface{}{"disable-compression": true}, clusters[0].Cluster.Extra)
+
+	users := []UserEntry{{Name: "jim_smurf", User: User{Extra: map[string]interface{}{
+		"client-certificate": "/etc/jim/client.crt",
+		"client-key":         "/etc/jim/client.key",
+		"tokenFile":          "/etc/jim/token",
+		"as":                 "admin",
+	}}}}
+	users = upsertUserEntry(users, UserEntry{Name: "jim_smurf", User: User{ClientCertificateData: "Y2VydA==", ClientKeyData: "a2V5"}})
+	assert.Equal(t, map[string]interface{}{"as": "admin"}, users[0].User.Extra)
+}
+
 func TestDeriveNamespaceFromRole(t *testing.T) {
 	testNamespace(t, "hase-user", "hase")
 	testNamespace(t, "hase-admin", "hase")
@@ -490,6 +726,16 @@ func testNamespace(t *testing.T, role string, expectedNamespace string) {
 	}
 }
 
+func metadataExtensions(cluster string, role string) []NamedExtension {
+	return []NamedExtension{{
+		Name: "vaultpal",
+		Extension: map[string]interface{}{
+			"cluster": cluster,
+			"role":    role,
+		},
+	}}
+}
+
 func assertKubeConfig(t *testing.T, want Config, gotRaw []byte) {
 	got, err := parseKubeConfig(gotRaw)
 	if err != nil {
@@ -594,7 +840,7 @@ func TestWriteKubeconfig(t *testing.T) {
 		"allow_any_name": true,
 	})
 
-	err = WriteKubeconfig("kube", "master")
+	err = WriteKubeconfig("kube", "master", WriteOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -689,7 +935,7 @@ func TestWriteKubeconfigWithAlias(t *testing.T) {
 		"allow_any_name": true,
 	})
 
-	err = WriteKubeconfig("lukas", "master")
+	err = WriteKubeconfig("lukas", "master", WriteOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -0,0 +1,156 @@
+package kube
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	clientauthv1 "k8s.io/client-go/pkg/apis/clientauthentication/v1"
+)
+
+const PATH_CREDS_TOKEN_F = "/v1/%s/creds/%s"
+
+// newTestToken creates an unsigned service account token for the subject, which expires at exp
+func newTestToken(t *testing.T, sub string, exp time.Time) string {
+	claims, err := json.Marshal(map[string]interface{}{
+		"sub": sub,
+		"iat": exp.Add(-time.Hour).Unix(),
+		"exp": exp.Unix(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+	return header + "." + base64.RawURLEncoding.EncodeToString(claims) + ".sig"
+}
+
+type mockTokenData struct {
+	token              string
+	namespace          string
+	clusterRoleBinding bool
+}
+
+func (m *mockTokenData) mockIssueToken(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	body := map[string]interface{}{}
+	err := json.NewDecoder(r.Body).Decode(&body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, m.namespace, body["kubernetes_namespace"])
+	assert.Equal(t, m.clusterRoleBinding, body["cluster_role_binding"])
+
+	w.Header().Set("Content-Type", "application/json")
+	sec := api.Secret{
+		LeaseDuration: 600,
+		Data: map[string]interface{}{
+			"service_account_name":      "v-token-smurf",
+			"service_account_namespace": m.namespace,
+			"service_account_token":     m.token,
+		},
+	}
+	u.WriteJsonResponse(t, sec, w)
+}
+
+func TestWriteKubeconfigToken(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	token := newTestToken(t, "system:serviceaccount:ttb:v-token-smurf", time.Now().Add(10*time.Minute))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"kiki"] = (&mockData{clusterName: "kiki", serverURL: "kiki.tsc.sh", auth: "token", kubernetes: "k8s-kiki", ca: CA}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"lukas"] = (&mockData{clusterName: "lukas", serverURL: "lukas.tsc.sh", aliasName: "kiki"}).mockReadPalConfig
+	vm.ServeMocks[fmt.Sprintf(PATH_CREDS_TOKEN_F, "k8s-kiki", "ttb-user")] = (&mockTokenData{token: token, namespace: "ttb", clusterRoleBinding: true}).mockIssueToken
+
+	kubeC, _, err := renderKubeconfig([]byte{}, "lukas", "ttb-user", WriteOptions{ClusterRoleBinding: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(string(kubeC))
+
+	assertKubeConfig(t, Config{
+		ApiVersion: "v1",
+		Kind:       "Config",
+		Clusters: []ClusterEntry{{
+			Name: "lukas",
+			Cluster: Cluster{
+				Server:                   "lukas.tsc.sh",
+				CertificateAuthorityData: StringToBase64String(CA),
+			},
+		}},
+		Contexts: []ContextEntry{{
+			Name: "lukas",
+			Context: Context{
+				Cluster:   "lukas",
+				Namespace: "ttb",
+				User:      "lukas_smurf",
+				Extensions: []NamedExtension{{
+					Name: "vaultpal",
+					Extension: map[string]interface{}{
+						"cluster":              "lukas",
+						"role":                 "ttb-user",
+						"cluster_role_binding": true,
+					},
+				}},
+			},
+		}},
+		Users: []UserEntry{{
+			Name: "lukas_smurf",
+			User: User{Token: token},
+		}},
+		CurrentContext: "lukas",
+	}, kubeC)
+
+	k8, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status := handleStatus(k8, nil)
+	assert.Equal(t, CredentialToken, status[0].Type)
+	assert.Equal(t, "system:serviceaccount:ttb:v-token-smurf", status[0].CommonName)
+	assert.False(t, status[0].Expired())
+}
+
+func TestExecCredentialToken(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	os.Setenv(ENV_VAULTPAL_KUBE_CACHE_DIR, t.TempDir())
+	token := newTestToken(t, "system:serviceaccount:ttb:v-token-smurf", time.Now().Add(10*time.Minute))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"kiki"] = (&mockData{clusterName: "kiki", serverURL: "kiki.tsc.sh", auth: "token", kubernetes: "k8s-kiki"}).mockReadPalConfig
+	vm.ServeMocks[fmt.Sprintf(PATH_CREDS_TOKEN_F, "k8s-kiki", "ttb")] = (&mockTokenData{token: token, namespace: "ttb"}).mockIssueToken
+
+	out, err := handleExecCredential("kiki", "ttb", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := clientauthv1.ExecCredential{}
+	err = json.Unmarshal(out, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, token, got.Status.Token)
+	assert.Empty(t, got.Status.ClientCertificateData)
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), got.Status.ExpirationTimestamp.Time, time.Minute)
+}
+
+func TestCredentialExecConfigClusterRoleBinding(t *testing.T) {
+	e := credentialExecConfig("kiki", "ttb", WriteOptions{ClusterRoleBinding: true})
+	assert.Equal(t, []string{"kube", "credential", "kiki", "ttb", "--cluster-role-binding"}, e.Args)
+
+	cluster, role, ok := vaultpalExecTarget(e)
+	assert.True(t, ok)
+	assert.Equal(t, "kiki", cluster)
+	assert.Equal(t, "ttb", role)
+}
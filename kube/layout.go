@@ -0,0 +1,136 @@
+package kube
+
+import (
+	"bytes"
+
+	log "github.com/sirupsen/logrus"
+	yamlv3 "gopkg.in/yaml.v3"
+)
+
+// preserveLayout carries the comments and the key order of the kubeconfig old over to its rewritten content out,
+// which loses them when unmarshalled into Config. Entries of lists are matched by name, keys of maps by key.
+// If either of them cannot be parsed, out is returned as it is.
+func preserveLayout(old []byte, out []byte) []byte {
+	if len(bytes.TrimSpace(old)) == 0 || len(out) == 0 {
+		return out
+	}
+
+	oldDoc := &yamlv3.Node{}
+	newDoc := &yamlv3.Node{}
+	if yamlv3.Unmarshal(old, oldDoc) != nil || yamlv3.Unmarshal(out, newDoc) != nil {
+		return out
+	}
+	if !isMappingDocument(oldDoc) || !isMappingDocument(newDoc) {
+		return out
+	}
+
+	merged := *oldDoc
+	merged.Content = []*yamlv3.Node{mergeNode(oldDoc.Content[0], newDoc.Content[0])}
+
+	buf := &bytes.Buffer{}
+	enc := yamlv3.NewEncoder(buf)
+	enc.SetIndent(2)
+	err := enc.Encode(&merged)
+	if err == nil {
+		err = enc.Close()
+	}
+	if err != nil {
+		log.WithError(err).Debug("cannot preserve the layout of the kubeconfig")
+		return out
+	}
+	return buf.Bytes()
+}
+
+// mergeNode returns the content of n in the layout of o
+func mergeNode(o *yamlv3.Node, n *yamlv3.Node) *yamlv3.Node {
+	if o.Kind != n.Kind {
+		return n
+	}
+
+	switch n.Kind {
+	case yamlv3.MappingNode:
+		return mergeMapping(o, n)
+	case yamlv3.SequenceNode:
+		return mergeSequence(o, n)
+	case yamlv3.ScalarNode:
+		if o.Value == n.Value && o.Tag == n.Tag {
+			return o
+		}
+		merged := *n
+		merged.HeadComment, merged.LineComment, merged.FootComment = o.HeadComment, o.LineComment, o.FootComment
+		return &merged
+	default:
+		return n
+	}
+}
+
+// mergeMapping keeps the keys of o in their order, drops the ones missing in n and appends the ones new in n
+func mergeMapping(o *yamlv3.Node, n *yamlv3.Node) *yamlv3.Node {
+	merged := *o
+	merged.Content = nil
+	seen := map[string]bool{}
+	for i := 0; i+1 < len(o.Content); i += 2 {
+		key := o.Content[i].Value
+		if v := mappingValue(n, key); v != nil {
+			merged.Content = append(merged.Content, o.Content[i], mergeNode(o.Content[i+1], v))
+			seen[key] = true
+		}
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if !seen[n.Content[i].Value] {
+			merged.Content = append(merged.Content, n.Content[i], n.Content[i+1])
+		}
+	}
+	return &merged
+}
+
+// mergeSequence keeps the order of n. Entries with a name are merged with the entry of the same name in o,
+// other entries with the entry at the same position.
+func mergeSequence(o *yamlv3.Node, n *yamlv3.Node) *yamlv3.Node {
+	merged := *o
+	merged.Content = nil
+	for i, nc := range n.Content {
+		var oc *yamlv3.Node
+		if name := entryName(nc); name != "" {
+			for _, c := range o.Content {
+				if entryName(c) == name {
+					oc = c
+					break
+				}
+			}
+		} else if i < len(o.Content) && entryName(o.Content[i]) == "" {
+			oc = o.Content[i]
+		}
+
+		if oc == nil {
+			merged.Content = append(merged.Content, nc)
+		} else {
+			merged.Content = append(merged.Content, mergeNode(oc, nc))
+		}
+	}
+	return &merged
+}
+
+func isMappingDocument(doc *yamlv3.Node) bool {
+	return doc.Kind == yamlv3.DocumentNode && len(doc.Content) == 1 && doc.Content[0].Kind == yamlv3.MappingNode
+}
+
+func mappingValue(m *yamlv3.Node, key string) *yamlv3.Node {
+	for i := 0; i+1 < len(m.Content); i += 2 {
+		if m.Content[i].Value == key {
+			return m.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// entryName returns the name of a list entry like a cluster, context or user, empty if it has none
+func entryName(e *yamlv3.Node) string {
+	if e.Kind != yamlv3.MappingNode {
+		return ""
+	}
+	if v := mappingValue(e, "name"); v != nil && v.Kind == yamlv3.ScalarNode {
+		return v.Value
+	}
+	return ""
+}
@@ -0,0 +1,64 @@
+package kube
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const COMMENTED_KUBECONFIG = `# managed by hand
+apiVersion: v1
+kind: Config
+preferences: {}
+current-context: foreign
+clusters:
+  - name: foreign # the production cluster
+    cluster:
+      server: https://foreign.tsc.sh
+      insecure-skip-tls-verify: true
+contexts:
+  - name: foreign
+    context:
+      user: foreign-user
+      cluster: foreign
+users:
+  # static token of the ci
+  - name: foreign-user
+    user:
+      token: abc
+`
+
+func TestPreserveLayout(t *testing.T) {
+	k8, err := parseKubeConfig([]byte(COMMENTED_KUBECONFIG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k8.Contexts = upsertContextEntry(k8.Contexts, ContextEntry{Name: "jim", Context: Context{Cluster: "jim", User: "jim_smurf"}})
+	k8.Users[0].User.Token = "def"
+	k8.CurrentContext = "jim"
+	out, err := yaml.Marshal(k8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := string(preserveLayout([]byte(COMMENTED_KUBECONFIG), out))
+	t.Log(got)
+	assert.Contains(t, got, "# managed by hand\n")
+	assert.Contains(t, got, "- name: foreign # the production cluster\n")
+	assert.Contains(t, got, "# static token of the ci\n")
+	assert.Contains(t, got, "current-context: jim\n")
+	assert.Contains(t, got, "token: def\n")
+	// the key order of the original file is kept
+	assert.Less(t, strings.Index(got, "current-context:"), strings.Index(got, "clusters:"))
+	assert.Less(t, strings.Index(got, "user: foreign-user"), strings.Index(got, "cluster: foreign\n"))
+
+	merged, err := parseKubeConfig([]byte(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, k8, merged)
+
+	assert.Equal(t, out, preserveLayout([]byte("not: [valid"), out))
+}
@@ -1,47 +1,194 @@
 package kube
 
-import "encoding/base64"
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	log "github.com/sirupsen/logrus"
+)
+
+const metadataExtensionName = "vaultpal"
+
+// All kubeconfig types keep fields unknown to vaultpal in Extra,
+// so that they survive when vaultpal rewrites a kubeconfig.
+// Comments and key order are lost on the way and restored by preserveLayout.
 
 type Cluster struct {
-	Server                   string `yaml:"server"`
-	CertificateAuthorityData string `yaml:"certificate-authority-data"`
+	Server                   string                 `yaml:"server"`
+	CertificateAuthorityData string                 `yaml:"certificate-authority-data,omitempty"`
+	ProxyURL                 string                 `yaml:"proxy-url,omitempty"`
+	TLSServerName            string                 `yaml:"tls-server-name,omitempty"`
+	Extensions               []NamedExtension       `yaml:"extensions,omitempty"`
+	Extra                    map[string]interface{} `yaml:",inline"`
 }
 
 type ClusterEntry struct {
-	Name    string  `yaml:"name"`
-	Cluster Cluster `yaml:"cluster"`
+	Name    string                 `yaml:"name"`
+	Cluster Cluster                `yaml:"cluster"`
+	Extra   map[string]interface{} `yaml:",inline"`
 }
 
 type ContextEntry struct {
-	Name    string  `yaml:"name"`
-	Context Context `yaml:"context"`
+	Name    string                 `yaml:"name"`
+	Context Context                `yaml:"context"`
+	Extra   map[string]interface{} `yaml:",inline"`
 }
 
 type Context struct {
-	Cluster   string `yaml:"cluster"`
-	Namespace string `yaml:"namespace"`
-	User      string `yaml:"user"`
+	Cluster    string                 `yaml:"cluster"`
+	Namespace  string                 `yaml:"namespace,omitempty"`
+	User       string                 `yaml:"user"`
+	Extensions []NamedExtension       `yaml:"extensions,omitempty"`
+	Extra      map[string]interface{} `yaml:",inline"`
+}
+
+type NamedExtension struct {
+	Name      string                 `yaml:"name"`
+	Extension map[string]interface{} `yaml:"extension"`
+}
+
+// Metadata records how vaultpal wrote a context. It is stored as context extension named "vaultpal".
+type Metadata struct {
+	// Cluster is the name of the cluster in the registry
+	Cluster string `mapstructure:"cluster"`
+	Role    string `mapstructure:"role"`
+	// Serial is the serial number of the static client certificate, used to revoke it
+	Serial string `mapstructure:"serial,omitempty"`
+	// The options the credentials were requested with, so they can be reissued the same way, see WriteOptions
+	Namespace          string   `mapstructure:"namespace,omitempty"`
+	TTL                string   `mapstructure:"ttl,omitempty"`
+	KeyType            string   `mapstructure:"key_type,omitempty"`
+	KeyBits            int      `mapstructure:"key_bits,omitempty"`
+	AltNames           []string `mapstructure:"alt_names,omitempty"`
+	Sign               bool     `mapstructure:"sign,omitempty"`
+	ClusterRoleBinding bool     `mapstructure:"cluster_role_binding,omitempty"`
+}
+
+// writeOptions rebuilds the options the context was written with
+func (m *Metadata) writeOptions() WriteOptions {
+	opts := WriteOptions{
+		Namespace:          m.Namespace,
+		KeyType:            m.KeyType,
+		KeyBits:            m.KeyBits,
+		AltNames:           m.AltNames,
+		Sign:               m.Sign,
+		ClusterRoleBinding: m.ClusterRoleBinding,
+	}
+	if m.TTL != "" {
+		ttl, err := time.ParseDuration(m.TTL)
+		if err != nil {
+			log.WithError(err).WithField("TTL", m.TTL).Warn("ignore invalid ttl of context metadata")
+		}
+		opts.TTL = ttl
+	}
+	return opts
 }
 
 type UserEntry struct {
-	Name string `yaml:"name"`
-	User User   `yaml:"user"`
+	Name  string                 `yaml:"name"`
+	User  User                   `yaml:"user"`
+	Extra map[string]interface{} `yaml:",inline"`
 }
 
 type User struct {
-	ClientCertificateData string `yaml:"client-certificate-data"`
-	ClientKeyData         string `yaml:"client-key-data"`
+	ClientCertificateData string                 `yaml:"client-certificate-data,omitempty"`
+	ClientKeyData         string                 `yaml:"client-key-data,omitempty"`
+	Token                 string                 `yaml:"token,omitempty"`
+	Exec                  *ExecConfig            `yaml:"exec,omitempty"`
+	Extra                 map[string]interface{} `yaml:",inline"`
+}
+
+// ExecConfig lets kubectl fetch the user credentials from an external command (exec credential plugin)
+type ExecConfig struct {
+	ApiVersion         string                 `yaml:"apiVersion"`
+	Command            string                 `yaml:"command"`
+	Args               []string               `yaml:"args,omitempty"`
+	InteractiveMode    string                 `yaml:"interactiveMode,omitempty"`
+	ProvideClusterInfo bool                   `yaml:"provideClusterInfo,omitempty"`
+	Extra              map[string]interface{} `yaml:",inline"`
 }
 
 type Config struct {
-	ApiVersion     string         `yaml:"apiVersion"`
-	Kind           string         `yaml:"kind"`
-	Clusters       []ClusterEntry `yaml:"clusters"`
-	Contexts       []ContextEntry `yaml:"contexts"`
-	Users          []UserEntry    `yaml:"users"`
-	CurrentContext string         `yaml:"current-context"`
+	ApiVersion     string                 `yaml:"apiVersion"`
+	Kind           string                 `yaml:"kind"`
+	Clusters       []ClusterEntry         `yaml:"clusters"`
+	Contexts       []ContextEntry         `yaml:"contexts"`
+	Users          []UserEntry            `yaml:"users"`
+	CurrentContext string                 `yaml:"current-context"`
+	Extra          map[string]interface{} `yaml:",inline"`
 }
 
 func StringToBase64String(s string) string {
 	return base64.StdEncoding.EncodeToString(([]byte(s)))
 }
+
+// Metadata returns the vaultpal metadata of the context, or nil if the context was not written by vaultpal
+func (c *Context) Metadata() *Metadata {
+	for _, e := range c.Extensions {
+		if e.Name == metadataExtensionName {
+			m := &Metadata{}
+			if err := mapstructure.Decode(e.Extension, m); err != nil {
+				return nil
+			}
+			return m
+		}
+	}
+	return nil
+}
+
+// SetMetadata stores the vaultpal metadata in the context extensions
+func (c *Context) SetMetadata(m Metadata) {
+	ext := map[string]interface{}{}
+	_ = mapstructure.Decode(m, &ext)
+	c.Extensions = mergeExtensions(c.Extensions, []NamedExtension{{
+		Name:      metadataExtensionName,
+		Extension: ext,
+	}})
+}
+
+// SetLabels stores the labels of the registry in the cluster extensions
+func (c *Cluster) SetLabels(labels map[string]string) {
+	if len(labels) == 0 {
+		return
+	}
+	ext := map[string]interface{}{}
+	for k, v := range labels {
+		ext[k] = v
+	}
+	c.Extensions = mergeExtensions(c.Extensions, []NamedExtension{{
+		Name:      metadataExtensionName,
+		Extension: map[string]interface{}{"labels": ext},
+	}})
+}
+
+// mergeExtensions replaces the extensions of old by the ones of new with the same name
+func mergeExtensions(old []NamedExtension, new []NamedExtension) []NamedExtension {
+	merged := []NamedExtension{}
+	for _, o := range old {
+		replaced := false
+		for _, n := range new {
+			if n.Name == o.Name {
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, o)
+		}
+	}
+	return append(merged, new...)
+}
+
+// withoutKeys returns a copy of extra without the given keys
+func withoutKeys(extra map[string]interface{}, keys ...string) map[string]interface{} {
+	if extra == nil {
+		return nil
+	}
+	out := map[string]interface{}{}
+	for k, v := range extra {
+		if !contains(keys, k) {
+			out[k] = v
+		}
+	}
+	return out
+}
@@ -0,0 +1,90 @@
+package kube
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/dbschenker/vaultpal/config"
+	"github.com/pkg/errors"
+)
+
+// defaultNamespaceRules strip the role suffixes known to vaultpal, see deriveNamespaceFromRole
+var defaultNamespaceRules = []config.NamespaceRule{
+	{Suffix: "-user"},
+	{Suffix: "-admin"}, // also support -admin, even though it's currently not used
+}
+
+// namespaceRules returns the rules deriving namespaces from roles in order of precedence:
+// rules of the local vaultpal config, rules of the cluster, rules of the aliased cluster and the default rules
+func namespaceRules(cf config.KubeCluster, issuer config.KubeCluster) ([]config.NamespaceRule, error) {
+	rules, err := config.GetKubeNamespaceRules()
+	if err != nil {
+		return nil, err
+	}
+
+	rules = append(rules, cf.Namespaces...)
+	if cf.Alias != "" {
+		rules = append(rules, issuer.Namespaces...)
+	}
+	return append(rules, defaultNamespaceRules...), nil
+}
+
+// resolveNamespace returns the namespace of the kubeconfig context for role.
+// An explicitly given namespace takes precedence over the namespace rules of cluster and local config.
+func resolveNamespace(role string, explicit string, cf config.KubeCluster, issuer config.KubeCluster) (string, error) {
+	if explicit != "" {
+		return explicit, nil
+	}
+
+	rules, err := namespaceRules(cf, issuer)
+	if err != nil {
+		return "", err
+	}
+	return deriveNamespace(role, rules)
+}
+
+// deriveNamespace applies the first matching rule to role. The role is returned, if no rule matches.
+func deriveNamespace(role string, rules []config.NamespaceRule) (string, error) {
+	for _, r := range rules {
+		namespace, ok, err := applyNamespaceRule(r, role)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return namespace, nil
+		}
+	}
+	return role, nil
+}
+
+func applyNamespaceRule(r config.NamespaceRule, role string) (string, bool, error) {
+	switch {
+	case r.Suffix != "" && r.Regex != "":
+		return "", false, errors.Errorf("namespace rule must have either suffix [%s] or regex [%s]", r.Suffix, r.Regex)
+	case r.Suffix != "":
+		if strings.HasSuffix(role, r.Suffix) && len(role) > len(r.Suffix) {
+			return strings.TrimSuffix(role, r.Suffix), true, nil
+		}
+		return "", false, nil
+	case r.Regex != "":
+		re, err := regexp.Compile(r.Regex)
+		if err != nil {
+			return "", false, errors.Wrapf(err, "invalid regex of namespace rule [%s]", r.Regex)
+		}
+		match := re.FindStringSubmatchIndex(role)
+		if match == nil {
+			return "", false, nil
+		}
+		template := r.Namespace
+		if template == "" {
+			template = "$1"
+		}
+		namespace := string(re.ExpandString(nil, template, role, match))
+		if namespace == "" {
+			return "", false, errors.Errorf("namespace rule [%s] derives an empty namespace from role [%s]", r.Regex, role)
+		}
+		return namespace, true, nil
+	default:
+		return "", false, errors.New("namespace rule must have a suffix or regex")
+	}
+}
@@ -0,0 +1,106 @@
+package kube
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dbschenker/vaultpal/config"
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeriveNamespace(t *testing.T) {
+	tests := []struct {
+		Name    string
+		Role    string
+		Rules   []config.NamespaceRule
+		Want    string
+		WantErr string
+	}{
+		{Name: "default suffix", Role: "hase-user", Rules: defaultNamespaceRules, Want: "hase"},
+		{Name: "no match", Role: "hase-readonly", Rules: defaultNamespaceRules, Want: "hase-readonly"},
+		{Name: "suffix only", Role: "-ci", Rules: []config.NamespaceRule{{Suffix: "-ci"}}, Want: "-ci"},
+		{Name: "first match wins", Role: "hase-ci-user", Rules: []config.NamespaceRule{{Suffix: "-ci-user"}, {Suffix: "-user"}}, Want: "hase"},
+		{Name: "regex default template", Role: "hase-breakglass", Rules: []config.NamespaceRule{{Regex: "^(.+)-(breakglass|readonly)$"}}, Want: "hase"},
+		{Name: "regex named template", Role: "ci-hase-int", Rules: []config.NamespaceRule{{Regex: "^ci-(?P<topic>[a-z]+)-(?P<env>[a-z]+)$", Namespace: "${topic}-${env}"}}, Want: "hase-int"},
+		{Name: "regex no match", Role: "hase", Rules: []config.NamespaceRule{{Regex: "^ci-(.+)$"}}, Want: "hase"},
+		{Name: "invalid regex", Role: "hase", Rules: []config.NamespaceRule{{Regex: "("}}, WantErr: "invalid regex of namespace rule [(]: error parsing regexp: missing closing ): `(`"},
+		{Name: "empty namespace", Role: "hase", Rules: []config.NamespaceRule{{Regex: "^hase$"}}, WantErr: "namespace rule [^hase$] derives an empty namespace from role [hase]"},
+		{Name: "suffix and regex", Role: "hase", Rules: []config.NamespaceRule{{Suffix: "-ci", Regex: "ci"}}, WantErr: "namespace rule must have either suffix [-ci] or regex [ci]"},
+		{Name: "empty rule", Role: "hase", Rules: []config.NamespaceRule{{}}, WantErr: "namespace rule must have a suffix or regex"},
+	}
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			got, err := deriveNamespace(test.Role, test.Rules)
+			if test.WantErr != "" {
+				assert.EqualError(t, err, test.WantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, test.Want, got)
+		})
+	}
+}
+
+func TestResolveNamespace(t *testing.T) {
+	defer viper.Reset()
+	viper.Set(config.KeyKubeNamespaces, []map[string]interface{}{
+		{"suffix": "-ci"},
+	})
+
+	cf := config.KubeCluster{Name: "lukas", Alias: "jim", Namespaces: []config.NamespaceRule{{Suffix: "-readonly"}}}
+	issuer := config.KubeCluster{Name: "jim", Namespaces: []config.NamespaceRule{{Regex: "^(.+)-(readonly|breakglass)$"}, {Suffix: "-ci"}}}
+
+	tests := []struct {
+		Role     string
+		Explicit string
+		Want     string
+	}{
+		{Role: "hase-ci", Want: "hase"},
+		{Role: "hase-readonly", Want: "hase"},
+		{Role: "hase-breakglass", Want: "hase"},
+		{Role: "hase-user", Want: "hase"},
+		{Role: "hase-user", Explicit: "igel", Want: "igel"},
+	}
+	for _, test := range tests {
+		got, err := resolveNamespace(test.Role, test.Explicit, cf, issuer)
+		assert.NoError(t, err)
+		assert.Equal(t, test.Want, got, test.Role)
+	}
+
+	// rules of the aliased cluster only apply to aliases
+	got, err := resolveNamespace("hase-breakglass", "", issuer, issuer)
+	assert.NoError(t, err)
+	assert.Equal(t, "hase", got)
+	got, err = resolveNamespace("hase-breakglass", "", config.KubeCluster{Name: "emma"}, issuer)
+	assert.NoError(t, err)
+	assert.Equal(t, "hase-breakglass", got)
+}
+
+func TestWriteKubeconfigNamespace(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	os.Setenv(ENV_VAULTPAL_KUBE_CACHE_DIR, t.TempDir())
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "hase-ci")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	kubeC, _, err := renderKubeconfig([]byte{}, "jim", "hase-ci", WriteOptions{Namespace: "hase", Exec: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "hase", got.Contexts[0].Context.Namespace)
+	assert.Equal(t, []string{"kube", "credential", "jim", "hase-ci", "--namespace", "hase"}, got.Users[0].User.Exec.Args)
+}
@@ -0,0 +1,276 @@
+package kube
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	PruneReasonExpired      = "certificate expired"
+	PruneReasonUnregistered = "cluster not in registry"
+	PruneReasonUnreferenced = "unreferenced"
+)
+
+// PruneOptions control which entries Prune removes from the vaultpal kubeconfig
+type PruneOptions struct {
+	// DryRun lists the entries that would be removed without changing the kubeconfig
+	DryRun bool
+}
+
+// PrunedEntry is a cluster, context or user removed from the kubeconfig
+type PrunedEntry struct {
+	Kind   string `json:"kind" yaml:"kind"`
+	Name   string `json:"name" yaml:"name"`
+	Reason string `json:"reason" yaml:"reason"`
+}
+
+// Prune removes stale entries from the vaultpal kubeconfig:
+// contexts with expired client certificates, contexts of clusters removed from the registry,
+// and users and clusters no context references anymore.
+func Prune(format string, opts PruneOptions) error {
+	if opts.DryRun {
+		_, kubeConfigRaw, err := readPalKubeConfig()
+		if err != nil {
+			return err
+		}
+		_, pruned, err := handlePrune(kubeConfigRaw)
+		if err != nil {
+			return err
+		}
+		return utils.WriteOutput(os.Stdout, format, pruned, func(w io.Writer) {
+			_, _ = fmt.Fprintln(w, "KIND\tNAME\tREASON")
+			for _, p := range pruned {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", p.Kind, p.Name, p.Reason)
+			}
+		})
+	}
+
+	palKubeConfigFile, err := ensurePalKubeConfigFile()
+	if err != nil {
+		return err
+	}
+
+	var pruned []PrunedEntry
+	err = updateKubeConfig(TargetVaultpal, palKubeConfigFile, func(kconfig []byte) ([]byte, error) {
+		out, p, err := handlePrune(kconfig)
+		if err != nil || len(p) == 0 {
+			return nil, err
+		}
+		pruned = p
+		return out, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(pruned) == 0 {
+		log.Info("nothing to prune")
+		return nil
+	}
+	logPruned(pruned)
+	return nil
+}
+
+func handlePrune(kconfig []byte) ([]byte, []PrunedEntry, error) {
+	k8, err := parseKubeConfig(kconfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var reg *registry
+	if hasMetadata(k8) {
+		client, err := vault.NewClient()
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "error creating vault api client")
+		}
+
+		reg, err = newRegistry(client)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	pruned, err := pruneConfig(k8, reg, "")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out, err := yaml.Marshal(k8)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot marshal kubeconfig file")
+	}
+	return out, pruned, nil
+}
+
+// pruneConfig removes stale entries from k8 and returns them. Only entries written by vaultpal are considered:
+// with a prefix those whose name starts with it, otherwise contexts carrying vaultpal metadata, the users and
+// clusters they reference and users of the vaultpal exec credential plugin.
+// Contexts are checked against the registry, if reg is given and the context carries vaultpal metadata.
+func pruneConfig(k8 *Config, reg *registry, prefix string) ([]PrunedEntry, error) {
+	pruned := []PrunedEntry{}
+
+	expired := map[string]bool{}
+	for _, ue := range k8.Users {
+		expired[ue.Name] = userExpired(ue.User)
+	}
+
+	managedUsers := map[string]bool{}
+	managedClusters := map[string]bool{}
+	for _, ce := range k8.Contexts {
+		if managedContext(ce, prefix) {
+			managedUsers[ce.Context.User] = true
+			managedClusters[ce.Context.Cluster] = true
+		}
+	}
+	for _, ue := range k8.Users {
+		if prefix != "" {
+			managedUsers[ue.Name] = strings.HasPrefix(ue.Name, prefix)
+		} else if ue.User.Exec != nil {
+			_, _, ok := vaultpalExecTarget(ue.User.Exec)
+			managedUsers[ue.Name] = managedUsers[ue.Name] || ok
+		}
+	}
+	for _, ce := range k8.Clusters {
+		if prefix != "" {
+			managedClusters[ce.Name] = strings.HasPrefix(ce.Name, prefix)
+		}
+	}
+
+	registered := map[string]bool{}
+	contexts := []ContextEntry{}
+	for _, ce := range k8.Contexts {
+		if !managedContext(ce, prefix) {
+			contexts = append(contexts, ce)
+			continue
+		}
+
+		if expired[ce.Context.User] {
+			pruned = append(pruned, PrunedEntry{Kind: "context", Name: ce.Name, Reason: PruneReasonExpired})
+			continue
+		}
+
+		if m := ce.Context.Metadata(); reg != nil && m != nil && m.Cluster != "" {
+			exists, ok := registered[m.Cluster]
+			if !ok {
+				kc, err := reg.read(m.Cluster)
+				if err != nil {
+					return nil, err
+				}
+				exists = kc != nil
+				registered[m.Cluster] = exists
+			}
+			if !exists {
+				pruned = append(pruned, PrunedEntry{Kind: "context", Name: ce.Name, Reason: PruneReasonUnregistered})
+				continue
+			}
+		}
+
+		contexts = append(contexts, ce)
+	}
+
+	referencedUsers := map[string]bool{}
+	referencedClusters := map[string]bool{}
+	for _, ce := range contexts {
+		referencedUsers[ce.Context.User] = true
+		referencedClusters[ce.Context.Cluster] = true
+	}
+
+	users := []UserEntry{}
+	for _, ue := range k8.Users {
+		if referencedUsers[ue.Name] || !managedUsers[ue.Name] {
+			users = append(users, ue)
+			continue
+		}
+		reason := PruneReasonUnreferenced
+		if expired[ue.Name] {
+			reason = PruneReasonExpired
+		}
+		pruned = append(pruned, PrunedEntry{Kind: "user", Name: ue.Name, Reason: reason})
+	}
+
+	clusters := []ClusterEntry{}
+	for _, ce := range k8.Clusters {
+		if referencedClusters[ce.Name] || !managedClusters[ce.Name] {
+			clusters = append(clusters, ce)
+			continue
+		}
+		pruned = append(pruned, PrunedEntry{Kind: "cluster", Name: ce.Name, Reason: PruneReasonUnreferenced})
+	}
+
+	k8.Contexts = contexts
+	k8.Users = users
+	k8.Clusters = clusters
+
+	for _, p := range pruned {
+		if p.Kind == "context" && p.Name == k8.CurrentContext {
+			k8.CurrentContext = ""
+		}
+	}
+
+	return pruned, nil
+}
+
+// userExpired reports, if the static client certificate or service account token of user is expired.
+// Users of exec credential plugins renew their credentials themselves and never expire.
+func userExpired(user User) bool {
+	if user.Exec != nil {
+		return false
+	}
+	if user.Token != "" {
+		claims, err := parseTokenClaims(user.Token)
+		if err != nil {
+			return false
+		}
+		return time.Now().After(time.Unix(claims.Expiry, 0))
+	}
+	if user.ClientCertificateData == "" {
+		return false
+	}
+	certPEM, err := base64.StdEncoding.DecodeString(user.ClientCertificateData)
+	if err != nil {
+		return false
+	}
+	cert, err := parseCertificate(string(certPEM))
+	if err != nil {
+		return false
+	}
+	return time.Now().After(cert.NotAfter)
+}
+
+// managedContext reports, if the context was written by vaultpal: its name starts with prefix or,
+// without a prefix, it carries vaultpal metadata
+func managedContext(ce ContextEntry, prefix string) bool {
+	if prefix != "" {
+		return strings.HasPrefix(ce.Name, prefix)
+	}
+	return ce.Context.Metadata() != nil
+}
+
+func hasMetadata(k8 *Config) bool {
+	for _, ce := range k8.Contexts {
+		if ce.Context.Metadata() != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func logPruned(pruned []PrunedEntry) {
+	for _, p := range pruned {
+		log.WithFields(log.Fields{
+			"Kind":   p.Kind,
+			"Name":   p.Name,
+			"Reason": p.Reason,
+		}).Info("pruned")
+	}
+}
@@ -0,0 +1,136 @@
+package kube
+
+import (
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+// staleKubeConfig returns a kubeconfig with one valid context per cluster, which
+// is only stale when the cluster is expired or missing from the registry
+func staleKubeConfig(t *testing.T, expired ...string) []byte {
+	k8 := Config{ApiVersion: "v1", Kind: "Config", CurrentContext: "emma"}
+	for _, cluster := range []string{"jim", "lukas", "emma"} {
+		notAfter := time.Now().Add(time.Hour)
+		if contains(expired, cluster) {
+			notAfter = time.Now().Add(-time.Minute)
+		}
+		cert, key := newTestCertificate(t, "smurf", notAfter)
+
+		ce := ContextEntry{Name: cluster, Context: Context{Cluster: cluster, Namespace: "master", User: cluster + "_smurf"}}
+		ce.Context.SetMetadata(Metadata{Cluster: cluster, Role: "master"})
+		k8.Contexts = append(k8.Contexts, ce)
+		k8.Clusters = append(k8.Clusters, ClusterEntry{Name: cluster, Cluster: Cluster{Server: cluster + ".tsc.sh"}})
+		k8.Users = append(k8.Users, UserEntry{Name: cluster + "_smurf", User: User{
+			ClientCertificateData: StringToBase64String(cert),
+			ClientKeyData:         StringToBase64String(key),
+		}})
+	}
+	k8.Users = append(k8.Users, UserEntry{Name: "ghost_smurf", User: User{Exec: credentialExecConfig("ghost", "master", WriteOptions{})}})
+	k8.Clusters = append(k8.Clusters, ClusterEntry{Name: "ghost", Cluster: Cluster{Server: "ghost.tsc.sh"}})
+
+	raw, err := yaml.Marshal(k8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return raw
+}
+
+// entryNames returns the names of the contexts, users and clusters of k8
+func entryNames(k8 *Config) ([]string, []string, []string) {
+	contexts, users, clusters := []string{}, []string{}, []string{}
+	for _, ce := range k8.Contexts {
+		contexts = append(contexts, ce.Name)
+	}
+	for _, ue := range k8.Users {
+		users = append(users, ue.Name)
+	}
+	for _, ce := range k8.Clusters {
+		clusters = append(clusters, ce.Name)
+	}
+	return contexts, users, clusters
+}
+
+func TestPrune(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"lukas"] = (&u.MockErrorData{Errors: &[]string{}, HTTPStatus: http.StatusNotFound}).MockErrorResponse
+
+	// hand-added entries without vaultpal metadata are never pruned, even if expired or unreferenced
+	k8, err := parseKubeConfig(staleKubeConfig(t, "emma"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	k8.Contexts = append(k8.Contexts, ContextEntry{Name: "foreign", Context: Context{Cluster: "foreign", User: "foreign-user"}})
+	k8.Users = append(k8.Users, UserEntry{Name: "foreign-user", User: User{ClientCertificateData: StringToBase64String(CERT)}})
+	k8.Clusters = append(k8.Clusters, ClusterEntry{Name: "foreign", Cluster: Cluster{Server: "foreign.tsc.sh"}})
+	raw, err := yaml.Marshal(k8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, pruned, err := handlePrune(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.ElementsMatch(t, []PrunedEntry{
+		{Kind: "context", Name: "lukas", Reason: PruneReasonUnregistered},
+		{Kind: "context", Name: "emma", Reason: PruneReasonExpired},
+		{Kind: "user", Name: "lukas_smurf", Reason: PruneReasonUnreferenced},
+		{Kind: "user", Name: "emma_smurf", Reason: PruneReasonExpired},
+		{Kind: "user", Name: "ghost_smurf", Reason: PruneReasonUnreferenced},
+		{Kind: "cluster", Name: "lukas", Reason: PruneReasonUnreferenced},
+		{Kind: "cluster", Name: "emma", Reason: PruneReasonUnreferenced},
+	}, pruned)
+
+	got, err := parseKubeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contexts, users, clusters := entryNames(got)
+	assert.Equal(t, []string{"jim", "foreign"}, contexts)
+	assert.Equal(t, []string{"jim_smurf", "foreign-user"}, users)
+	assert.Equal(t, []string{"jim", "ghost", "foreign"}, clusters)
+	assert.Equal(t, "", got.CurrentContext)
+}
+
+func TestWriteKubeconfigPrune(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"lukas"] = (&mockData{clusterName: "lukas", pkiName: "k8s-pki", serverURL: "lukas.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"emma"] = (&mockData{clusterName: "emma", pkiName: "k8s-pki", serverURL: "emma.tsc.sh"}).mockReadPalConfig
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	kubeC, _, err := renderKubeconfig(staleKubeConfig(t, "jim", "emma"), "jim", "master", WriteOptions{Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// jim was expired, but has just been rewritten
+	contexts, users, clusters := entryNames(got)
+	assert.Equal(t, []string{"jim", "lukas"}, contexts)
+	assert.Equal(t, []string{"jim_smurf", "lukas_smurf"}, users)
+	assert.Equal(t, []string{"jim", "lukas", "ghost"}, clusters)
+	assert.Equal(t, "jim", got.CurrentContext)
+}
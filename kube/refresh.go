@@ -0,0 +1,174 @@
+package kube
+
+import (
+	"sync"
+
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// DefaultRefreshParallelism is the number of contexts reissued concurrently by Refresh
+const DefaultRefreshParallelism = 4
+
+// refreshTarget is a context of the vaultpal kubeconfig, which can be reissued from its metadata
+type refreshTarget struct {
+	Context   string
+	Cluster   string
+	Role      string
+	Namespace string
+	Exec      bool
+	// Metadata the context was written with
+	Metadata Metadata
+}
+
+// writeOptions returns the options the context was written with, for the current namespace of the context
+func (t refreshTarget) writeOptions(target string) WriteOptions {
+	opts := t.Metadata.writeOptions()
+	opts.Exec = t.Exec
+	opts.Target = target
+	if t.Namespace != "" {
+		opts.Namespace = t.Namespace
+	}
+	return opts
+}
+
+type refreshResult struct {
+	entries *kubeEntries
+	err     error
+}
+
+// Refresh reissues the credentials of all contexts in the vaultpal kubeconfig, which were written by vaultpal.
+// Up to parallel contexts are reissued concurrently, a failing context does not abort the others.
+func Refresh(parallel int) error {
+	palKubeConfigFile, err := ensurePalKubeConfigFile()
+	if err != nil {
+		return err
+	}
+
+	// the kubeconfig stays locked while the contexts are reissued, so no concurrent write gets lost
+	var refreshErr error
+	err = updateKubeConfig(TargetVaultpal, palKubeConfigFile, func(kconfig []byte) ([]byte, error) {
+		var out []byte
+		out, refreshErr = handleRefresh(kconfig, parallel)
+		return out, nil
+	})
+	if err != nil {
+		return err
+	}
+	return refreshErr
+}
+
+// handleRefresh returns the refreshed kubeconfig, or nil if no context could be refreshed.
+// An error is returned along with the kubeconfig, if any of the contexts failed.
+func handleRefresh(kconfig []byte, parallel int) ([]byte, error) {
+	k8, err := parseKubeConfig(kconfig)
+	if err != nil {
+		return nil, err
+	}
+
+	targets := refreshTargets(k8)
+	if len(targets) == 0 {
+		log.Warn("kubeconfig contains no contexts written by vaultpal")
+		return nil, nil
+	}
+
+	client, err := vault.NewClient()
+	if err != nil {
+		return nil, errors.Wrap(err, "error creating vault api client")
+	}
+
+	identity, err := lookupIdentity(client)
+	if err != nil {
+		return nil, err
+	}
+
+	reg, err := newRegistry(client)
+	if err != nil {
+		return nil, err
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+
+	results := make([]refreshResult, len(targets))
+	jobs := make(chan int)
+	wg := sync.WaitGroup{}
+	for w := 0; w < parallel; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				t := targets[i]
+				entries, err := renderEntries(reg, identity, t.Cluster, t.Role, t.writeOptions(TargetVaultpal))
+				results[i] = refreshResult{entries: entries, err: err}
+			}
+		}()
+	}
+	for i := range targets {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	failed := 0
+	for i, t := range targets {
+		fields := log.Fields{
+			"Context": t.Context,
+			"Cluster": t.Cluster,
+			"Role":    t.Role,
+		}
+		r := results[i]
+		if r.err != nil {
+			failed++
+			log.WithFields(fields).WithError(r.err).Error("refresh failed")
+			continue
+		}
+
+		mergeEntries(k8, r.entries)
+		log.WithFields(fields).Info("refreshed")
+	}
+
+	if failed == len(targets) {
+		return nil, errors.Errorf("all %d contexts failed to refresh", failed)
+	}
+
+	out, err := yaml.Marshal(k8)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot marshal kubeconfig file")
+	}
+
+	if failed > 0 {
+		return out, errors.Errorf("%d of %d contexts failed to refresh", failed, len(targets))
+	}
+	return out, nil
+}
+
+// refreshTargets returns the contexts carrying vaultpal metadata, in the order of the kubeconfig
+func refreshTargets(k8 *Config) []refreshTarget {
+	targets := []refreshTarget{}
+	for _, ce := range k8.Contexts {
+		m := ce.Context.Metadata()
+		if m == nil || m.Cluster == "" || m.Role == "" {
+			log.WithField("Context", ce.Name).Debug("skip context without vaultpal metadata")
+			continue
+		}
+
+		t := refreshTarget{
+			Context:   ce.Name,
+			Cluster:   m.Cluster,
+			Role:      m.Role,
+			Namespace: ce.Context.Namespace,
+			Metadata:  *m,
+		}
+		for _, ue := range k8.Users {
+			if ue.Name == ce.Context.User && ue.User.Exec != nil {
+				_, _, t.Exec = vaultpalExecTarget(ue.User.Exec)
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets
+}
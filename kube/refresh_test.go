@@ -0,0 +1,141 @@
+package kube
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRefresh(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"emma"] = (&mockData{clusterName: "emma", pkiName: "k8s-pki-emma", serverURL: "emma.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"lukas"] = (&mockData{clusterName: "lukas", pkiName: "k8s-pki-lukas", serverURL: "lukas.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: CERT, privateKey: PRIVATE_KEY}).mockIssueCert
+	vm.ServeMocks[issueCertPath("k8s-pki-emma", "lokomotive")] = (&mockData{issuingCa: CA, cert: CERT, privateKey: PRIVATE_KEY}).mockIssueCert
+	vm.ServeMocks[issueCertPath("k8s-pki-lukas", "master")] = (&mockData{issuingCa: CA, cert: CERT, privateKey: PRIVATE_KEY}).mockIssueCert
+
+	kubeC := []byte{}
+	var err error
+	for _, c := range [][]string{{"jim", "master"}, {"lukas", "master"}, {"emma", "lokomotive"}} {
+		kubeC, _, err = renderKubeconfig(kubeC, c[0], c[1], WriteOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the namespace was switched by the user after writing the context
+	k8, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k8.Contexts[0].Context.Namespace = "switched"
+	kubeC, err = yaml.Marshal(k8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// lukas vanished from the registry, which must not abort the refresh of the other clusters
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"lukas"] = (&u.MockErrorData{Errors: &[]string{}, HTTPStatus: http.StatusNotFound}).MockErrorResponse
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+	vm.ServeMocks[issueCertPath("k8s-pki-emma", "lokomotive")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	out, err := handleRefresh(kubeC, 2)
+	assert.EqualError(t, err, "1 of 3 contexts failed to refresh")
+	if out == nil {
+		t.Fatal("expected refreshed kubeconfig")
+	}
+	t.Log(string(out))
+
+	got, err := parseKubeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "emma", got.CurrentContext)
+	assert.Len(t, got.Contexts, 3)
+	assert.Equal(t, "switched", got.Contexts[0].Context.Namespace)
+
+	certs := map[string]string{}
+	for _, ue := range got.Users {
+		certs[ue.Name] = ue.User.ClientCertificateData
+	}
+	assert.Equal(t, StringToBase64String(cert), certs["jim_smurf"])
+	assert.Equal(t, StringToBase64String(cert), certs["emma_smurf"])
+	assert.Equal(t, StringToBase64String(CERT), certs["lukas_smurf"])
+}
+
+func TestRefreshKeepsWriteOptions(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	os.Setenv(ENV_VAULTPAL_KUBE_CACHE_DIR, t.TempDir())
+	token := newTestToken(t, "system:serviceaccount:ttb:v-token-smurf", time.Now().Add(10*time.Minute))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"kiki"] = (&mockData{clusterName: "kiki", serverURL: "kiki.tsc.sh", auth: "token", kubernetes: "k8s-kiki", ca: CA}).mockReadPalConfig
+	// there is no mock of pki/issue, so the signed context must not be reissued by vault
+	vm.ServeMocks[fmt.Sprintf(PATH_SIGN_CERT_F, "k8s-pki", "master")] = mockSignCert
+	vm.ServeMocks[fmt.Sprintf(PATH_CREDS_TOKEN_F, "k8s-kiki", "ttb-user")] = (&mockTokenData{token: token, namespace: "ttb", clusterRoleBinding: true}).mockIssueToken
+
+	signOpts := WriteOptions{Exec: true, Sign: true, KeyType: KeyTypeEC, TTL: 2 * time.Hour, AltNames: []string{"smurf.tsc.sh"}}
+	kubeC, _, err := renderKubeconfig([]byte{}, "jim", "master", signOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	kubeC, _, err = renderKubeconfig(kubeC, "kiki", "ttb-user", WriteOptions{ClusterRoleBinding: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the namespace of the token context was switched by the user after writing it
+	k8, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	k8.Contexts[1].Context.Namespace = "switched"
+	kubeC, err = yaml.Marshal(k8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.ServeMocks[fmt.Sprintf(PATH_CREDS_TOKEN_F, "k8s-kiki", "ttb-user")] = (&mockTokenData{token: token, namespace: "switched", clusterRoleBinding: true}).mockIssueToken
+
+	out, err := handleRefresh(kubeC, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseKubeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signOpts.Namespace = "master"
+	assert.Equal(t, credentialExecConfig("jim", "master", signOpts).Args, got.Users[0].User.Exec.Args)
+	assert.Equal(t, "switched", got.Contexts[1].Context.Namespace)
+	assert.Equal(t, token, got.Users[1].User.Token)
+
+	m := got.Contexts[0].Context.Metadata()
+	if assert.NotNil(t, m) {
+		assert.Equal(t, WriteOptions{Sign: true, KeyType: KeyTypeEC, TTL: 2 * time.Hour, AltNames: []string{"smurf.tsc.sh"}, Namespace: "master"}, m.writeOptions())
+	}
+}
+
+func TestRefreshWithoutMetadata(t *testing.T) {
+	out, err := handleRefresh([]byte(FOREIGN_KUBECONFIG), 4)
+	assert.NoError(t, err)
+	assert.Nil(t, out)
+}
@@ -0,0 +1,131 @@
+package kube
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/dbschenker/vaultpal/config"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/mitchellh/mapstructure"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// registry reads the vaultpal cluster definitions from a vault kv secret engine
+type registry struct {
+	config.KubeRegistry
+	client *api.Client
+}
+
+func newRegistry(client *api.Client) (*registry, error) {
+	r := &registry{
+		KubeRegistry: config.GetKubeRegistry(),
+		client:       client,
+	}
+
+	if r.KVVersion == 0 {
+		version, err := detectKVVersion(client, r.Mount)
+		if err != nil {
+			return nil, err
+		}
+		r.KVVersion = version
+	}
+
+	if r.KVVersion != 1 && r.KVVersion != 2 {
+		return nil, errors.Errorf("unsupported kv version [%d] of cluster registry", r.KVVersion)
+	}
+
+	log.WithFields(log.Fields{
+		"Path":      r.Path(),
+		"KVVersion": r.KVVersion,
+	}).Debug("using cluster registry")
+
+	return r, nil
+}
+
+// detectKVVersion asks vault for the version of the kv secret engine at mount, like the vault cli does
+func detectKVVersion(client *api.Client, mount string) (int, error) {
+	secret, err := client.Logical().Read("sys/internal/ui/mounts/" + mount)
+	if err != nil {
+		return 0, errors.Wrapf(vault.CheckReachable(client, err), "error detecting kv version of mount [%s]", mount)
+	}
+	if secret == nil {
+		return 0, errors.Errorf("kv mount [%s] of cluster registry not found", mount)
+	}
+
+	options, ok := secret.Data["options"].(map[string]interface{})
+	if ok && options["version"] == "2" {
+		return 2, nil
+	}
+	return 1, nil
+}
+
+// clusterPath returns the api path of a cluster definition
+func (r *registry) clusterPath(cluster string) string {
+	if r.KVVersion == 2 {
+		return path.Join(r.Mount, "data", r.Prefix, cluster)
+	}
+	return path.Join(r.Mount, r.Prefix, cluster)
+}
+
+// clusterData returns the cluster definition of a kv read response
+func (r *registry) clusterData(secret *api.Secret) map[string]interface{} {
+	if r.KVVersion == 2 {
+		data, _ := secret.Data["data"].(map[string]interface{})
+		return data
+	}
+	return secret.Data
+}
+
+// read returns the definition of a cluster, or nil if it is undefined
+func (r *registry) read(cluster string) (*config.KubeCluster, error) {
+	secret, err := r.client.Logical().Read(r.clusterPath(cluster))
+	if err != nil {
+		return nil, errors.Wrapf(vault.CheckReachable(r.client, err), "error reading vaultpal config entry for cluster [%s]", cluster)
+	}
+	// vault answers deleted or missing kv entries with an empty secret
+	if secret == nil || len(r.clusterData(secret)) == 0 {
+		return nil, nil
+	}
+
+	cf := &config.KubeCluster{}
+	// numbers of vault responses are json.Number
+	err = mapstructure.WeakDecode(r.clusterData(secret), cf)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot decode vaultpal config entry for cluster [%s]", cluster)
+	}
+	return cf, nil
+}
+
+// list returns the names of all clusters defined in the registry
+func (r *registry) list() ([]string, error) {
+	listPath := path.Join(r.Mount, r.Prefix)
+	if r.KVVersion == 2 {
+		listPath = path.Join(r.Mount, "metadata", r.Prefix)
+	}
+
+	secret, err := r.client.Logical().List(listPath)
+	if err != nil {
+		return nil, errors.Wrapf(vault.CheckReachable(r.client, err), "error listing clusters of registry [%s]", r.Path())
+	}
+	if secret == nil {
+		return []string{}, nil
+	}
+
+	keys, ok := secret.Data["keys"].([]interface{})
+	if !ok {
+		return nil, errors.Errorf("unexpected list response of registry [%s]", r.Path())
+	}
+	clusters := []string{}
+	for _, k := range keys {
+		name, ok := k.(string)
+		// skip folders
+		if ok && !strings.HasSuffix(name, "/") {
+			clusters = append(clusters, name)
+		}
+	}
+	sort.Strings(clusters)
+	return clusters, nil
+}
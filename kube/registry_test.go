@@ -0,0 +1,121 @@
+package kube
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/dbschenker/vaultpal/config"
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+)
+
+func mockReadPalConfigKV1(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	sec := api.Secret{Data: map[string]interface{}{
+		"name":   "jim",
+		"pki":    "k8s-pki",
+		"server": "jim-knopf.tsc.sh",
+	}}
+	u.WriteJsonResponse(t, sec, w)
+}
+
+func TestRegistry(t *testing.T) {
+	tests := []struct {
+		Name       string
+		settings   map[string]interface{}
+		serveMocks map[string]u.ServeMockFunc
+		WantErr    string
+	}{
+		{
+			Name:     "Detect KV version 1",
+			settings: map[string]interface{}{config.KeyKubeRegistryMount: "secret", config.KeyKubeRegistryPrefix: "/teams/k8s/"},
+			serveMocks: map[string]u.ServeMockFunc{
+				"/v1/sys/internal/ui/mounts/secret": (&mockData{kvVersion: "1"}).mockKVMount,
+				"/v1/secret/teams/k8s/jim":          mockReadPalConfigKV1,
+			},
+		},
+		{
+			Name:     "Detect KV version 2",
+			settings: map[string]interface{}{config.KeyKubeRegistryMount: "secret", config.KeyKubeRegistryPrefix: "teams/k8s"},
+			serveMocks: map[string]u.ServeMockFunc{
+				"/v1/sys/internal/ui/mounts/secret": (&mockData{kvVersion: "2"}).mockKVMount,
+				"/v1/secret/data/teams/k8s/jim":     (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig,
+			},
+		},
+		{
+			Name:     "Configured KV version skips detection",
+			settings: map[string]interface{}{config.KeyKubeRegistryKVVersion: 1},
+			serveMocks: map[string]u.ServeMockFunc{
+				"/v1/kv/vaultbro/k8s/clusters/jim": mockReadPalConfigKV1,
+			},
+		},
+		{
+			Name:     "Unsupported KV version",
+			settings: map[string]interface{}{config.KeyKubeRegistryKVVersion: 3},
+			WantErr:  "unsupported kv version [3] of cluster registry",
+		},
+	}
+
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	client, err := vault.NewClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range tests {
+		t.Run(test.Name, func(t *testing.T) {
+			viper.Reset()
+			defer viper.Reset()
+			for k, v := range test.settings {
+				viper.Set(k, v)
+			}
+			vm.ServeMocks = test.serveMocks
+
+			reg, err := newRegistry(client)
+			if test.WantErr != "" {
+				assert.EqualError(t, err, test.WantErr)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cf, issuer, err := resolveCluster(reg, "jim")
+			if err != nil {
+				t.Fatal(err)
+			}
+			assert.Equal(t, config.KubeCluster{Name: "jim", PKI: "k8s-pki", Server: "jim-knopf.tsc.sh"}, cf)
+			assert.Equal(t, "k8s-pki", issuer.PKI)
+		})
+	}
+}
+
+func TestRegistryListUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	client, err := api.NewClient(&api.Config{Address: server.URL, MaxRetries: 0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	viper.Reset()
+	defer viper.Reset()
+	viper.Set(config.KeyKubeRegistryKVVersion, 2)
+
+	reg, err := newRegistry(client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = reg.list()
+	var unreachable *vault.UnreachableError
+	if assert.ErrorAs(t, err, &unreachable) {
+		assert.Equal(t, server.URL, unreachable.Address)
+	}
+}
@@ -0,0 +1,237 @@
+package kube
+
+import (
+	"os"
+	"reflect"
+
+	"github.com/dbschenker/vaultpal/config"
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// Revoke revokes the client certificates of the contexts written by vaultpal for cluster and removes the contexts
+// from the kubeconfig of target, so no valid credentials are left behind on the workstation.
+func Revoke(target string, cluster string) error {
+	kubeconfigFile, err := targetKubeConfigFile(target)
+	if err != nil {
+		return err
+	}
+
+	var removed []PrunedEntry
+	err = updateKubeConfig(target, kubeconfigFile, func(kconfig []byte) ([]byte, error) {
+		out, r, err := handleRevoke(kconfig, cluster)
+		if err != nil || len(r) == 0 {
+			return nil, err
+		}
+		removed = r
+		return out, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(removed) == 0 {
+		log.WithField("Cluster", cluster).Info("no contexts written by vaultpal found")
+		return nil
+	}
+
+	for _, r := range removed {
+		log.WithFields(log.Fields{
+			"Kind": r.Kind,
+			"Name": r.Name,
+		}).Info("removed")
+	}
+	return nil
+}
+
+func handleRevoke(kconfig []byte, cluster string) ([]byte, []PrunedEntry, error) {
+	k8, err := parseKubeConfig(kconfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := vault.NewClient()
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "error creating vault api client")
+	}
+
+	reg, err := newRegistry(client)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	users := map[string]User{}
+	for _, ue := range k8.Users {
+		users[ue.Name] = ue.User
+	}
+
+	revoked := map[string]bool{}
+	for _, ce := range k8.Contexts {
+		m := ce.Context.Metadata()
+		if m == nil || m.Cluster != cluster {
+			continue
+		}
+
+		serials := []string{m.Serial}
+		caches := []*credentialCache{}
+		if exec := users[ce.Context.User].Exec; exec != nil {
+			if _, _, ok := vaultpalExecTarget(exec); ok {
+				// the certificates cached for all vault tokens are revoked
+				caches, err = credentialCaches(exec)
+				if err != nil {
+					return nil, nil, err
+				}
+			}
+			for _, cache := range caches {
+				cached, err := cache.read()
+				if err != nil && !os.IsNotExist(err) {
+					return nil, nil, err
+				}
+				if cached != nil {
+					serials = append(serials, cached.Serial)
+				}
+			}
+		}
+
+		for _, serial := range serials {
+			if serial == "" || revoked[serial] {
+				continue
+			}
+			err = revokeCertificate(reg, m.Cluster, serial)
+			if err != nil {
+				return nil, nil, err
+			}
+			revoked[serial] = true
+		}
+
+		for _, cache := range caches {
+			err = cache.remove()
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	return handleRemove(kconfig, []string{cluster})
+}
+
+// replacedCredentials are the client certificates of a context replaced by WriteKubeconfig. They are revoked
+// once the new context is stored, so a failed write does not leave the user without valid credentials.
+type replacedCredentials struct {
+	reg     *registry
+	cluster string
+	serials []string
+	// cache holds the revoked certificate of an exec user, which is removed along with it
+	cache *credentialCache
+}
+
+// replacedCache is the credential cache of the exec user of a context, which may be replaced by WriteKubeconfig
+type replacedCache struct {
+	context string
+	exec    *ExecConfig
+	cache   *credentialCache
+	creds   *credentials
+}
+
+// readReplacedCache reads the cached credentials of the vault token for the exec users of the contexts of cluster
+func readReplacedCache(client *api.Client, k8 *Config, cluster string) ([]*replacedCache, error) {
+	users := map[string]User{}
+	for _, ue := range k8.Users {
+		users[ue.Name] = ue.User
+	}
+
+	caches := []*replacedCache{}
+	for _, ce := range k8.Contexts {
+		m := ce.Context.Metadata()
+		exec := users[ce.Context.User].Exec
+		if m == nil || m.Cluster != cluster || exec == nil {
+			continue
+		}
+		if _, _, ok := vaultpalExecTarget(exec); !ok {
+			continue
+		}
+		cache, err := newCredentialCache(client, exec)
+		if err != nil {
+			return nil, err
+		}
+		creds, _ := cache.read()
+		caches = append(caches, &replacedCache{context: ce.Name, exec: exec, cache: cache, creds: creds})
+	}
+	return caches, nil
+}
+
+// newReplacedCredentials collects the client certificate of the context replaced by entries and the one of the
+// credential cache of its exec user. The cached certificate is only overwritten, if the new context requests
+// the same credentials by exec, otherwise the cache file is removed after revoking it.
+func newReplacedCredentials(reg *registry, k8 *Config, entries *kubeEntries, caches []*replacedCache) *replacedCredentials {
+	m := entries.Context.Context.Metadata()
+	r := &replacedCredentials{reg: reg, cluster: m.Cluster}
+	for _, c := range caches {
+		if c.context != entries.Context.Name || c.creds == nil || c.creds.Serial == "" {
+			continue
+		}
+		r.serials = append(r.serials, c.creds.Serial)
+		if exec := entries.User.User.Exec; exec == nil || !reflect.DeepEqual(exec.Args, c.exec.Args) {
+			r.cache = c.cache
+		}
+	}
+	for _, ce := range k8.Contexts {
+		if ce.Name != entries.Context.Name {
+			continue
+		}
+		if old := ce.Context.Metadata(); old != nil && old.Serial != "" {
+			r.serials = append(r.serials, old.Serial)
+		}
+	}
+
+	serials := []string{}
+	for _, serial := range r.serials {
+		if serial != m.Serial && !contains(serials, serial) {
+			serials = append(serials, serial)
+		}
+	}
+	r.serials = serials
+	return r
+}
+
+// revoke revokes the replaced client certificates. Failures are only logged, as the new credentials are stored already.
+func (r *replacedCredentials) revoke() {
+	for _, serial := range r.serials {
+		err := revokeCertificate(r.reg, r.cluster, serial)
+		if err != nil {
+			log.WithError(err).WithField("Serial", serial).Warn("cannot revoke replaced client certificate")
+		}
+	}
+	if r.cache != nil {
+		err := r.cache.remove()
+		if err != nil {
+			log.WithError(err).Warn("cannot remove cached credentials")
+		}
+	}
+}
+
+// revokeCertificate revokes the client certificate with serial at the pki of cluster
+func revokeCertificate(reg *registry, cluster string, serial string) error {
+	_, issuer, err := resolveCluster(reg, cluster)
+	if err != nil {
+		return err
+	}
+	if issuer.AuthMode() != config.KubeAuthCertificate {
+		return nil
+	}
+
+	_, err = reg.client.Logical().Write(issuer.PKI+"/revoke", map[string]interface{}{
+		"serial_number": serial,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "cannot revoke client certificate [%s] of cluster %s", serial, cluster)
+	}
+
+	log.WithFields(log.Fields{
+		"Cluster": cluster,
+		"Serial":  serial,
+	}).Info("revoked client certificate")
+	return nil
+}
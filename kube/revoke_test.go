@@ -0,0 +1,150 @@
+package kube
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+const PATH_REVOKE_CERT_F = "/v1/%s/revoke"
+
+// mockPKI issues certificates with increasing serial numbers and records revoked serials
+type mockPKI struct {
+	cert    string
+	key     string
+	issued  int
+	revoked []string
+}
+
+func (m *mockPKI) mockIssueCert(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	m.issued++
+	w.Header().Set("Content-Type", "application/json")
+	u.WriteJsonResponse(t, api.Secret{Data: map[string]interface{}{
+		"issuing_ca":    CA,
+		"certificate":   m.cert,
+		"private_key":   m.key,
+		"serial_number": fmt.Sprintf("39:dd:2e:%02d", m.issued),
+	}}, w)
+}
+
+func (m *mockPKI) mockRevokeCert(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	m.revoked = append(m.revoked, body["serial_number"].(string))
+	w.Header().Set("Content-Type", "application/json")
+	u.WriteJsonResponse(t, api.Secret{Data: map[string]interface{}{
+		"revocation_time": time.Now().Unix(),
+	}}, w)
+}
+
+func newMockPKI(t *testing.T, vm *u.VaultServerMock) *mockPKI {
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	pki := &mockPKI{cert: cert, key: key}
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = pki.mockIssueCert
+	vm.ServeMocks[fmt.Sprintf(PATH_REVOKE_CERT_F, "k8s-pki")] = pki.mockRevokeCert
+	return pki
+}
+
+func TestWriteKubeconfigRevokeReplaced(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	os.Setenv(ENV_VAULTPAL_KUBE_CACHE_DIR, t.TempDir())
+	pki := newMockPKI(t, vm)
+
+	kubeC, _, err := renderKubeconfig([]byte{}, "jim", "master", WriteOptions{Revoke: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, pki.revoked)
+
+	got, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "39:dd:2e:01", got.Contexts[0].Context.Metadata().Serial)
+
+	kubeC, replaced, err := renderKubeconfig(kubeC, "jim", "master", WriteOptions{Revoke: true, Exec: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// nothing is revoked before the new kubeconfig is stored
+	assert.Empty(t, pki.revoked)
+	replaced.revoke()
+	assert.Equal(t, []string{"39:dd:2e:01"}, pki.revoked)
+
+	// the serial of exec users is taken from the credential cache
+	kubeC, replaced, err = renderKubeconfig(kubeC, "jim", "master", WriteOptions{Revoke: true, Exec: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced.revoke()
+	assert.Equal(t, []string{"39:dd:2e:01", "39:dd:2e:02"}, pki.revoked)
+
+	_, replaced, err = renderKubeconfig(kubeC, "jim", "master", WriteOptions{Revoke: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"39:dd:2e:01", "39:dd:2e:02"}, pki.revoked)
+
+	// replacing an exec user by a static one removes the revoked certificate from the credential cache
+	replaced.revoke()
+	assert.Equal(t, []string{"39:dd:2e:01", "39:dd:2e:02", "39:dd:2e:03"}, pki.revoked)
+	caches, err := credentialCaches(credentialExecConfig("jim", "master", WriteOptions{}))
+	assert.NoError(t, err)
+	assert.Empty(t, caches, "the revoked certificate is removed from the cache")
+}
+
+func TestRevoke(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	os.Setenv(ENV_VAULTPAL_KUBE_CACHE_DIR, t.TempDir())
+	pki := newMockPKI(t, vm)
+
+	kubeC, _, err := renderKubeconfig([]byte(FOREIGN_KUBECONFIG), "jim", "master", WriteOptions{Exec: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, removed, err := handleRevoke(kubeC, "jim")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []string{"39:dd:2e:01"}, pki.revoked)
+	assert.Equal(t, []PrunedEntry{
+		{Kind: "context", Name: "jim"},
+		{Kind: "user", Name: "jim_smurf"},
+		{Kind: "cluster", Name: "jim"},
+	}, removed)
+
+	caches, err := credentialCaches(credentialExecConfig("jim", "master", WriteOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, caches)
+
+	got, err := parseKubeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := parseKubeConfig([]byte(FOREIGN_KUBECONFIG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, foreign.Contexts, got.Contexts)
+}
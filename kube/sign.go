@@ -0,0 +1,151 @@
+package kube
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"strings"
+
+	"github.com/dbschenker/vaultpal/vault"
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	defaultRSAKeyBits = 2048
+	defaultECKeyBits  = 256
+)
+
+// signCredentials creates a key pair locally and lets the pki role sign a certificate for the identity.
+// In contrast to issueCredentials, the private key never leaves the workstation.
+func signCredentials(client *api.Client, pki string, role string, identity string, req credentialRequest) (*credentials, error) {
+	key, keyPEM, err := generatePrivateKey(req.KeyType, req.KeyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject: pkix.Name{CommonName: identity},
+	}, key)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot create certificate signing request")
+	}
+
+	data := map[string]interface{}{
+		"csr":         string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})),
+		"common_name": identity,
+		"ttl":         ttlSeconds(req.TTL),
+	}
+	if len(req.AltNames) > 0 {
+		data["alt_names"] = joinAltNames(req.AltNames)
+	}
+	secret, err := client.Logical().Write(pki+"/sign/"+role, data)
+	if err != nil {
+		return nil, &IssueError{Path: pki + "/sign/" + role, Err: errors.Wrap(vault.CheckReachable(client, err), "error signing client key for cluster")}
+	}
+	if secret == nil {
+		return nil, &IssueError{Path: pki + "/sign/" + role, Err: errors.Errorf("no client certificate signed by [%s/sign/%s]", pki, role)}
+	}
+
+	creds := &credentials{PrivateKey: keyPEM}
+	creds.Certificate, err = vault.GetVerifiedSecretString(secret, "certificate", true)
+	if err != nil {
+		return nil, err
+	}
+	creds.IssuingCA, err = signedCAChain(secret)
+	if err != nil {
+		return nil, err
+	}
+	creds.Serial, _ = vault.GetVerifiedSecretString(secret, "serial_number", false)
+	creds.Expiration, err = issuedExpiration(secret, creds.Certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	log.WithFields(log.Fields{
+		"KeyType": formatKeyType(keyTypeOrDefault(req.KeyType), req.KeyBits),
+	}).Debug("signed locally generated client key")
+
+	return creds, nil
+}
+
+// signedCAChain returns the CA chain of a signed certificate, falling back to the issuing CA
+func signedCAChain(secret *api.Secret) (string, error) {
+	if chain, ok := secret.Data["ca_chain"].([]interface{}); ok && len(chain) > 0 {
+		pems := []string{}
+		for _, c := range chain {
+			s, ok := c.(string)
+			if !ok {
+				return "", errors.New("item value of [ca_chain] in secret data cannot be converted to string")
+			}
+			pems = append(pems, strings.TrimSpace(s))
+		}
+		return strings.Join(pems, "\n") + "\n", nil
+	}
+	return vault.GetVerifiedSecretString(secret, "issuing_ca", true)
+}
+
+func keyTypeOrDefault(keyType string) string {
+	if keyType == "" {
+		return KeyTypeEC
+	}
+	return keyType
+}
+
+// generatePrivateKey creates a private key of keyType, by default an ec P-256 key, and returns it PEM encoded
+// in the format vault issues keys in.
+func generatePrivateKey(keyType string, keyBits int) (crypto.Signer, string, error) {
+	switch keyTypeOrDefault(keyType) {
+	case KeyTypeRSA:
+		if keyBits == 0 {
+			keyBits = defaultRSAKeyBits
+		}
+		key, err := rsa.GenerateKey(rand.Reader, keyBits)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "cannot generate rsa key")
+		}
+		return key, string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})), nil
+	case KeyTypeEC:
+		var curve elliptic.Curve
+		switch keyBits {
+		case 224:
+			curve = elliptic.P224()
+		case 0, defaultECKeyBits:
+			curve = elliptic.P256()
+		case 384:
+			curve = elliptic.P384()
+		case 521:
+			curve = elliptic.P521()
+		default:
+			return nil, "", errors.Errorf("unsupported ec key bits [%d], must be one of [224 256 384 521]", keyBits)
+		}
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "cannot generate ec key")
+		}
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "cannot marshal ec key")
+		}
+		return key, string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})), nil
+	case KeyTypeEd25519:
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "cannot generate ed25519 key")
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, "", errors.Wrap(err, "cannot marshal ed25519 key")
+		}
+		return key, string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+	default:
+		return nil, "", errors.Errorf("unsupported key type [%s], must be one of %v", keyType, KeyTypes)
+	}
+}
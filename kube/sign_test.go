@@ -0,0 +1,166 @@
+package kube
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+const PATH_SIGN_CERT_F = "/v1/%s/sign/%s"
+
+// mockSignCert signs the certificate signing request of the body with a throwaway CA, like the pki sign endpoint does
+func mockSignCert(t *testing.T, w http.ResponseWriter, r *http.Request) {
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	assert.NotContains(t, body, "private_key")
+
+	block, _ := pem.Decode([]byte(body["csr"].(string)))
+	if block == nil {
+		t.Fatal("no csr in request")
+	}
+	csr, err := x509.ParseCertificateRequest(block.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, csr.CheckSignature())
+	assert.Equal(t, body["common_name"], csr.Subject.CommonName)
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "k8s-pki"},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDer, err := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      csr.Subject,
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, caTmpl, csr.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	u.WriteJsonResponse(t, api.Secret{Data: map[string]interface{}{
+		"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		"issuing_ca":  string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer})),
+		"ca_chain":    []string{string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDer}))},
+	}}, w)
+}
+
+func TestWriteKubeconfigSign(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[fmt.Sprintf(PATH_SIGN_CERT_F, "k8s-pki", "master")] = mockSignCert
+
+	for _, keyType := range KeyTypes {
+		t.Run(keyType, func(t *testing.T) {
+			kubeC, _, err := renderKubeconfig([]byte{}, "jim", "master", WriteOptions{Sign: true, KeyType: keyType})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := parseKubeConfig(kubeC)
+			if err != nil {
+				t.Fatal(err)
+			}
+			user := got.Users[0].User
+			certPEM, err := base64.StdEncoding.DecodeString(user.ClientCertificateData)
+			assert.NoError(t, err)
+			keyPEM, err := base64.StdEncoding.DecodeString(user.ClientKeyData)
+			assert.NoError(t, err)
+
+			// the certificate signed by vault belongs to the locally generated key
+			_, err = tls.X509KeyPair(certPEM, keyPEM)
+			assert.NoError(t, err)
+			gotType, _, err := privateKeyType(string(keyPEM))
+			assert.NoError(t, err)
+			assert.Equal(t, keyType, gotType)
+		})
+	}
+}
+
+func TestWriteKubeconfigKeyTypeSigns(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh", credentials: map[string]interface{}{
+		"key_type": "rsa",
+		"key_bits": 3072,
+	}}).mockReadPalConfig
+	// no issue endpoint is mocked, the requested key is generated locally and signed
+	vm.ServeMocks[fmt.Sprintf(PATH_SIGN_CERT_F, "k8s-pki", "master")] = mockSignCert
+
+	kubeC, _, err := renderKubeconfig([]byte{}, "jim", "master", WriteOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := base64.StdEncoding.DecodeString(got.Users[0].User.ClientKeyData)
+	assert.NoError(t, err)
+	keyType, keyBits, err := privateKeyType(string(keyPEM))
+	assert.NoError(t, err)
+	assert.Equal(t, KeyTypeRSA, keyType)
+	assert.Equal(t, 3072, keyBits)
+}
+
+func TestGeneratePrivateKey(t *testing.T) {
+	_, keyPEM, err := generatePrivateKey("", 0)
+	assert.NoError(t, err)
+	keyType, keyBits, err := privateKeyType(keyPEM)
+	assert.NoError(t, err)
+	assert.Equal(t, KeyTypeEC, keyType)
+	assert.Equal(t, 256, keyBits)
+
+	_, keyPEM, err = generatePrivateKey(KeyTypeRSA, 3072)
+	assert.NoError(t, err)
+	keyType, keyBits, err = privateKeyType(keyPEM)
+	assert.NoError(t, err)
+	assert.Equal(t, KeyTypeRSA, keyType)
+	assert.Equal(t, 3072, keyBits)
+
+	_, _, err = generatePrivateKey(KeyTypeEC, 512)
+	assert.EqualError(t, err, "unsupported ec key bits [512], must be one of [224 256 384 521]")
+}
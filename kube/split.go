@@ -0,0 +1,50 @@
+package kube
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/pkg/errors"
+)
+
+const (
+	// SplitCluster writes one kubeconfig file per cluster
+	SplitCluster = "cluster"
+	// SplitRole writes one kubeconfig file per cluster and role
+	SplitRole = "role"
+
+	splitFileExt = ".yaml"
+)
+
+var Splits = []string{SplitCluster, SplitRole}
+
+// splitKubeConfigFile returns the kubeconfig file of cluster and role next to the vaultpal kubeconfig,
+// e.g. ~/.vaultpal/kube/int.yaml or ~/.vaultpal/kube/int_webclaims-dev.yaml
+func splitKubeConfigFile(split string, cluster string, role string) (string, error) {
+	name := ""
+	switch split {
+	case SplitCluster:
+		name = cluster
+	case SplitRole:
+		name = cluster + "_" + role
+	default:
+		return "", errors.Errorf("unknown kubeconfig split [%s], must be one of %v", split, Splits)
+	}
+
+	palKubeConfigFile, err := ensurePalKubeConfigFile()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(filepath.Dir(palKubeConfigFile), splitFileName(name)+splitFileExt), nil
+}
+
+// splitFileName keeps names of clusters and roles with path separators inside the kube dir
+func splitFileName(name string) string {
+	return strings.NewReplacer("/", "-", "\\", "-").Replace(name)
+}
+
+// exportKubeConfig renders the KUBECONFIG assignment of file for the host shell
+func exportKubeConfig(file string) (string, error) {
+	return utils.ExportEnv(utils.EnvVar{Name: ENV_KUBECONFIG, Value: file})
+}
@@ -0,0 +1,109 @@
+package kube
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSplitKubeConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(ENV_VAULTPAL_KUBECONFIG_FILE, filepath.Join(dir, "config"))
+
+	tests := []struct {
+		name    string
+		split   string
+		cluster string
+		role    string
+		want    string
+		wantErr string
+	}{
+		{name: "Cluster", split: SplitCluster, cluster: "int", role: "webclaims-dev", want: "int.yaml"},
+		{name: "Role", split: SplitRole, cluster: "int", role: "webclaims-dev", want: "int_webclaims-dev.yaml"},
+		{name: "Path Separator", split: SplitRole, cluster: "int", role: "team/dev", want: "int_team-dev.yaml"},
+		{name: "Unknown Split", split: "namespace", cluster: "int", role: "webclaims-dev", wantErr: "unknown kubeconfig split [namespace], must be one of [cluster role]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitKubeConfigFile(tt.split, tt.cluster, tt.role)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, filepath.Join(dir, tt.want), got)
+		})
+	}
+}
+
+func TestWriteKubeconfigSplit(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	dir := t.TempDir()
+	t.Setenv(ENV_VAULTPAL_KUBECONFIG_FILE, filepath.Join(dir, "config"))
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	err := WriteKubeconfig("jim", "master", WriteOptions{Target: TargetVaultpal, Split: SplitRole})
+	assert.NoError(t, err)
+
+	raw, err := os.ReadFile(filepath.Join(dir, "jim_master.yaml"))
+	assert.NoError(t, err)
+	k8, err := parseKubeConfig(raw)
+	assert.NoError(t, err)
+	assert.Equal(t, "jim", k8.CurrentContext)
+	assert.Len(t, k8.Contexts, 1)
+
+	// the shared kubeconfig is not written in split mode
+	_, err = os.Stat(filepath.Join(dir, "config"))
+	assert.True(t, os.IsNotExist(err))
+
+	err = WriteKubeconfig("jim", "master", WriteOptions{Target: TargetKubeconfig, Split: SplitCluster})
+	assert.EqualError(t, err, "cannot split contexts into files of their own with target [kubeconfig]")
+}
+
+func TestWriteKubeconfigNoSwitch(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"jim"] = (&mockData{clusterName: "jim", pkiName: "k8s-pki", serverURL: "jim-knopf.tsc.sh"}).mockReadPalConfig
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	kubeC, _, err := renderKubeconfig([]byte(FOREIGN_KUBECONFIG), "jim", "master", WriteOptions{NoSwitch: true})
+	assert.NoError(t, err)
+
+	got, err := parseKubeConfig(kubeC)
+	assert.NoError(t, err)
+	assert.Equal(t, "foreign", got.CurrentContext)
+	found := false
+	for _, ce := range got.Contexts {
+		found = found || ce.Name == "jim"
+	}
+	assert.True(t, found, "context jim is written")
+}
+
+func TestExportKubeConfigQuoted(t *testing.T) {
+	file := utils.EnvVar{Name: ENV_KUBECONFIG, Value: "/home/j doe/.vaultpal/kube/it's $HOME.yaml"}
+	assert.Equal(t, `export KUBECONFIG='/home/j doe/.vaultpal/kube/it'\''s $HOME.yaml'`, utils.ExportLines(utils.ShellPosix, file))
+	assert.Equal(t, `$env:KUBECONFIG='/home/j doe/.vaultpal/kube/it''s $HOME.yaml'`, utils.ExportLines(utils.ShellPowerShell, file))
+	assert.Equal(t, `set "KUBECONFIG=/home/j doe/.vaultpal/kube/it's $HOME.yaml"`, utils.ExportLines(utils.ShellCmd, file))
+
+	// plain paths stay readable
+	assert.Equal(t, "export KUBECONFIG=/home/jdoe/.vaultpal/kube/jim_master.yaml",
+		utils.ExportLines(utils.ShellPosix, utils.EnvVar{Name: ENV_KUBECONFIG, Value: "/home/jdoe/.vaultpal/kube/jim_master.yaml"}))
+}
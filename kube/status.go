@@ -0,0 +1,241 @@
+package kube
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/dbschenker/vaultpal/timer"
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/pkg/errors"
+)
+
+// ErrCredentialsExpired is returned by Status, if any of the inspected credentials is expired
+var ErrCredentialsExpired = errors.New("kubeconfig contains expired credentials")
+
+const (
+	CredentialCertificate = "certificate"
+	CredentialToken       = "token"
+	CredentialExec        = "exec"
+)
+
+// CredentialStatus describes the client certificate or service account token of a user written by vaultpal
+type CredentialStatus struct {
+	User       string    `json:"user" yaml:"user"`
+	Context    string    `json:"context,omitempty" yaml:"context,omitempty"`
+	Cluster    string    `json:"cluster,omitempty" yaml:"cluster,omitempty"`
+	Role       string    `json:"role,omitempty" yaml:"role,omitempty"`
+	Namespace  string    `json:"namespace,omitempty" yaml:"namespace,omitempty"`
+	Type       string    `json:"type" yaml:"type"`
+	CommonName string    `json:"commonName,omitempty" yaml:"commonName,omitempty"`
+	NotBefore  time.Time `json:"notBefore,omitempty" yaml:"notBefore,omitempty"`
+	Expiration time.Time `json:"expiration,omitempty" yaml:"expiration,omitempty"`
+	Error      string    `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// TTL returns the remaining validity of the certificate
+func (s CredentialStatus) TTL() time.Duration {
+	return time.Until(s.Expiration)
+}
+
+// Expired reports, if there is no valid certificate for the user
+func (s CredentialStatus) Expired() bool {
+	return s.Error != "" || s.TTL() <= 0
+}
+
+// Status prints the client certificates in the vaultpal kubeconfig with their remaining validity.
+// Only users of contexts written by vaultpal are inspected, if clusters are given only those of contexts for these clusters.
+// ErrCredentialsExpired is returned, if any of the certificates is expired.
+func Status(format string, clusters ...string) error {
+	_, kubeConfigRaw, err := readPalKubeConfig()
+	if err != nil {
+		return err
+	}
+
+	k8, err := parseKubeConfig(kubeConfigRaw)
+	if err != nil {
+		return err
+	}
+
+	status := handleStatus(k8, clusters)
+
+	err = utils.WriteOutput(os.Stdout, format, status, func(w io.Writer) {
+		_, _ = fmt.Fprintln(w, "CLUSTER\tCOMMON NAME\tROLE\tNAMESPACE\tTYPE\tEXPIRES")
+		for _, s := range status {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				orNone(s.Cluster), orNone(s.CommonName), orNone(s.Role), orNone(s.Namespace), s.Type, formatExpiry(s))
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, s := range status {
+		if s.Expired() {
+			return ErrCredentialsExpired
+		}
+	}
+	return nil
+}
+
+func handleStatus(k8 *Config, clusters []string) []CredentialStatus {
+	status := []CredentialStatus{}
+
+	for _, ue := range k8.Users {
+		s := CredentialStatus{User: ue.Name}
+
+		// the context provides cluster, namespace and the role the certificate was issued for.
+		// Users without a context carrying vaultpal metadata were not written by vaultpal and are skipped.
+		var m *Metadata
+		for _, ce := range k8.Contexts {
+			if ce.Context.User == ue.Name {
+				if m = ce.Context.Metadata(); m != nil {
+					s.Context = ce.Name
+					s.Cluster = m.Cluster
+					s.Role = m.Role
+					s.Namespace = ce.Context.Namespace
+					break
+				}
+			}
+		}
+		if m == nil {
+			continue
+		}
+
+		if len(clusters) > 0 && !contains(clusters, s.Cluster) {
+			continue
+		}
+
+		err := inspectUser(ue.User, &s)
+		if err != nil {
+			s.Error = err.Error()
+		}
+
+		status = append(status, s)
+	}
+	return status
+}
+
+// inspectUser fills in the validity of the client certificate or service account token of a kubeconfig user,
+// which are taken from the credential cache for users of the vaultpal exec credential plugin
+func inspectUser(user User, s *CredentialStatus) error {
+	if user.Exec != nil {
+		s.Type = CredentialExec
+		_, role, ok := vaultpalExecTarget(user.Exec)
+		if !ok {
+			return errors.Errorf("exec command %s is not managed by vaultpal", user.Exec.Command)
+		}
+		s.Role = role
+		creds := latestCachedCredential(user.Exec)
+		if creds == nil {
+			return errors.New("no cached credentials")
+		}
+		if creds.Token != "" {
+			return inspectToken(creds.Token, s)
+		}
+		return inspectCertificate(creds.Certificate, s)
+	}
+
+	if user.Token != "" {
+		s.Type = CredentialToken
+		return inspectToken(user.Token, s)
+	}
+
+	s.Type = CredentialCertificate
+	if user.ClientCertificateData == "" {
+		return errors.New("no client certificate data")
+	}
+	certPEM, err := base64.StdEncoding.DecodeString(user.ClientCertificateData)
+	if err != nil {
+		return errors.Wrap(err, "cannot decode client certificate data")
+	}
+	return inspectCertificate(string(certPEM), s)
+}
+
+func inspectCertificate(certPEM string, s *CredentialStatus) error {
+	cert, err := parseCertificate(certPEM)
+	if err != nil {
+		return err
+	}
+	s.CommonName = cert.Subject.CommonName
+	s.NotBefore = cert.NotBefore
+	s.Expiration = cert.NotAfter
+	return nil
+}
+
+func inspectToken(token string, s *CredentialStatus) error {
+	claims, err := parseTokenClaims(token)
+	if err != nil {
+		return err
+	}
+	s.CommonName = claims.Subject
+	s.NotBefore = time.Unix(claims.IssuedAt, 0)
+	s.Expiration = time.Unix(claims.Expiry, 0)
+	return nil
+}
+
+// vaultpalExecTarget returns cluster and role of an exec credential plugin stanza written by vaultpal
+func vaultpalExecTarget(e *ExecConfig) (string, string, bool) {
+	if e.Command != execCommand || len(e.Args) < 4 || e.Args[0] != "kube" || e.Args[1] != "credential" {
+		return "", "", false
+	}
+	return e.Args[2], e.Args[3], true
+}
+
+// latestCachedCredential returns the cached credentials of the exec config, which expire last,
+// as the status does not look up the vault token they were cached for
+func latestCachedCredential(e *ExecConfig) *credentials {
+	caches, err := credentialCaches(e)
+	if err != nil {
+		return nil
+	}
+	var latest *credentials
+	for _, cache := range caches {
+		creds, err := cache.read()
+		if err == nil && creds != nil && (latest == nil || creds.Expiration.After(latest.Expiration)) {
+			latest = creds
+		}
+	}
+	return latest
+}
+
+func formatExpiry(s CredentialStatus) string {
+	if s.Error != "" {
+		return timer.LevelColor(timer.Red).Sprintf("<%s>", s.Error)
+	}
+
+	ttl := s.TTL()
+	if ttl <= 0 {
+		return timer.LevelColor(timer.Red).Sprintf("expired %s ago", formatDuration(-ttl))
+	}
+
+	level := timer.TTLLevel(ttl, s.Expiration.Sub(s.NotBefore))
+	return timer.LevelColor(level).Sprintf("in %s", formatDuration(ttl))
+}
+
+// formatDuration renders d in days, hours and minutes, e.g. 2d3h, 3h05m or 42m
+func formatDuration(d time.Duration) string {
+	d = d.Round(time.Minute)
+	days := d / (24 * time.Hour)
+	hours := (d % (24 * time.Hour)) / time.Hour
+	minutes := (d % time.Hour) / time.Minute
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%02dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, l := range list {
+		if l == s {
+			return true
+		}
+	}
+	return false
+}
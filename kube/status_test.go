@@ -0,0 +1,93 @@
+package kube
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func statusTestConfig(t *testing.T, validCert string) *Config {
+	jim := Context{Cluster: "jim", Namespace: "master", User: "jim_smurf"}
+	jim.SetMetadata(Metadata{Cluster: "jim", Role: "master"})
+	lukas := Context{Cluster: "lukas", Namespace: "master", User: "lukas_smurf"}
+	lukas.SetMetadata(Metadata{Cluster: "lukas", Role: "master"})
+	return &Config{
+		Contexts: []ContextEntry{
+			{Name: "jim", Context: jim},
+			{Name: "emma", Context: Context{Cluster: "emma", Namespace: "lokomotive", User: "emma_smurf"}},
+			{Name: "lukas", Context: lukas},
+			{Name: "foreign", Context: Context{Cluster: "foreign", User: "foreign-user"}},
+		},
+		Users: []UserEntry{
+			{Name: "jim_smurf", User: User{ClientCertificateData: StringToBase64String(validCert)}},
+			{Name: "emma_smurf", User: User{ClientCertificateData: StringToBase64String(CERT)}},
+			{Name: "lukas_smurf", User: User{Exec: credentialExecConfig("lukas", "master", WriteOptions{})}},
+			{Name: "foreign-user", User: User{Token: "abc"}},
+		},
+	}
+}
+
+func TestStatus(t *testing.T) {
+	os.Setenv(ENV_VAULTPAL_KUBE_CACHE_DIR, t.TempDir())
+	notAfter := time.Now().Add(time.Hour).Truncate(time.Second)
+	validCert, _ := newTestCertificate(t, "smurf", notAfter)
+	cachedCert, cachedKey := newTestCertificate(t, "smurf", notAfter)
+	prefix, err := credentialCachePrefix(credentialExecConfig("lukas", "master", WriteOptions{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := &credentialCache{file: prefix + "_token" + credentialCacheExt}
+	err = cache.write(&credentials{Certificate: cachedCert, PrivateKey: cachedKey, Expiration: notAfter})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status := handleStatus(statusTestConfig(t, validCert), nil)
+
+	// users without vaultpal metadata, like legacy or hand-added entries, are skipped
+	assert.Len(t, status, 2)
+	assert.Equal(t, CredentialStatus{
+		User: "jim_smurf", Context: "jim", Cluster: "jim", Role: "master", Namespace: "master", Type: CredentialCertificate,
+		CommonName: "smurf", NotBefore: notAfter.Add(-time.Hour).UTC(), Expiration: notAfter.UTC(),
+	}, status[0])
+	assert.False(t, status[0].Expired())
+
+	assert.Equal(t, CredentialExec, status[1].Type)
+	assert.Equal(t, "master", status[1].Role)
+	assert.Equal(t, notAfter.UTC(), status[1].Expiration)
+	assert.False(t, status[1].Expired())
+
+	filtered := handleStatus(statusTestConfig(t, validCert), []string{"jim", "lukas"})
+	assert.Len(t, filtered, 2)
+}
+
+func TestStatusExitError(t *testing.T) {
+	dir := t.TempDir()
+	os.Setenv(ENV_VAULTPAL_KUBE_CACHE_DIR, dir)
+	os.Setenv(ENV_VAULTPAL_KUBECONFIG_FILE, dir+"/config")
+	validCert, _ := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	raw, err := yaml.Marshal(statusTestConfig(t, validCert))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(dir+"/config", raw, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.NoError(t, Status("json", "jim"))
+	// the expired certificate of emma and the malformed token of foreign-user lack vaultpal metadata
+	assert.NoError(t, Status("json", "emma", "foreign"))
+	// no credentials are cached for the exec user of lukas
+	assert.ErrorIs(t, Status("json", "lukas"), ErrCredentialsExpired)
+	assert.ErrorIs(t, Status("json"), ErrCredentialsExpired)
+}
+
+func TestFormatDuration(t *testing.T) {
+	assert.Equal(t, "42m", formatDuration(42*time.Minute))
+	assert.Equal(t, "3h05m", formatDuration(3*time.Hour+5*time.Minute))
+	assert.Equal(t, "2d3h", formatDuration(51*time.Hour+10*time.Minute))
+}
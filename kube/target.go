@@ -0,0 +1,220 @@
+package kube
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/dbschenker/vaultpal/utils"
+	"github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	// TargetVaultpal is the kubeconfig file owned by vaultpal, see ENV_VAULTPAL_KUBECONFIG_FILE
+	TargetVaultpal = "vaultpal"
+	// TargetKubeconfig is the standard kubeconfig of kubectl, respecting the KUBECONFIG path list
+	TargetKubeconfig = "kubeconfig"
+
+	ENV_KUBECONFIG = "KUBECONFIG"
+
+	// entries merged into the standard kubeconfig are prefixed, so they never clobber entries of the user
+	managedPrefix = "vaultpal-"
+	backupSuffix  = ".vaultpal.bak"
+)
+
+var Targets = []string{TargetVaultpal, TargetKubeconfig}
+
+// readTargetKubeConfig returns the location and content of the kubeconfig file of target, which is created if it does not exist
+func readTargetKubeConfig(target string) (string, []byte, error) {
+	kubeconfigFile, err := targetKubeConfigFile(target)
+	if err != nil {
+		return "", nil, err
+	}
+
+	err = createPalKubeConfigFile(kubeconfigFile)
+	if err != nil {
+		return "", nil, err
+	}
+
+	kubeConfigRaw, err := os.ReadFile(kubeconfigFile)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "unable to read existing kube config [%s]", kubeconfigFile)
+	}
+	return kubeconfigFile, kubeConfigRaw, nil
+}
+
+// targetKubeConfigFile returns the location of the kubeconfig file of target, its dir is created if it does not exist
+func targetKubeConfigFile(target string) (string, error) {
+	switch target {
+	case "", TargetVaultpal:
+		return ensurePalKubeConfigFile()
+	case TargetKubeconfig:
+	default:
+		return "", errors.Errorf("unknown kubeconfig target [%s], must be one of %v", target, Targets)
+	}
+
+	kubeconfigFile, err := standardKubeConfigFile()
+	if err != nil {
+		return "", err
+	}
+
+	err = os.MkdirAll(filepath.Dir(kubeconfigFile), 0750)
+	if err != nil {
+		return "", errors.Wrapf(err, "cannot create dir [%s]", filepath.Dir(kubeconfigFile))
+	}
+	return kubeconfigFile, nil
+}
+
+// standardKubeConfigFile returns the first writable file of the KUBECONFIG path list, like kubectl does.
+// If none of the files exists, the first one is used. Without KUBECONFIG, ~/.kube/config is used.
+func standardKubeConfigFile() (string, error) {
+	files := []string{}
+	for _, f := range filepath.SplitList(os.Getenv(ENV_KUBECONFIG)) {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+
+	if len(files) == 0 {
+		home, err := homedir.Dir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, ".kube", "config"), nil
+	}
+
+	for _, f := range files {
+		if _, err := os.Stat(f); err != nil {
+			continue
+		}
+		fw, err := os.OpenFile(f, os.O_WRONLY, 0)
+		if err != nil {
+			log.WithField("File", f).Debug("skip kubeconfig, which is not writable")
+			continue
+		}
+		_ = fw.Close()
+		return f, nil
+	}
+	return files[0], nil
+}
+
+// updateKubeConfig locks the kubeconfig file of target and replaces it with the result of update, see utils.UpdateFile.
+// The standard kubeconfig is backed up before. Comments and key order of the kubeconfig are kept, see preserveLayout.
+func updateKubeConfig(target string, file string, update func(kconfig []byte) ([]byte, error)) error {
+	opts := utils.FileUpdateOptions{Perm: 0600}
+	if target == TargetKubeconfig {
+		opts.BackupSuffix = backupSuffix
+	}
+	return utils.UpdateFile(file, opts, func(kconfig []byte) ([]byte, error) {
+		out, err := update(kconfig)
+		if err != nil || out == nil {
+			return out, err
+		}
+		return preserveLayout(kconfig, out), nil
+	})
+}
+
+// prefixEntries renames the entries, so they can be told apart from entries not managed by vaultpal
+func prefixEntries(e *kubeEntries) {
+	e.Cluster.Name = managedPrefix + e.Cluster.Name
+	e.Context.Name = managedPrefix + e.Context.Name
+	e.Context.Context.Cluster = managedPrefix + e.Context.Context.Cluster
+	e.Context.Context.User = managedPrefix + e.Context.Context.User
+	e.User.Name = managedPrefix + e.User.Name
+}
+
+// Remove deletes the contexts written by vaultpal from the kubeconfig of target, together with their users and clusters.
+// If clusters are given, only contexts for these clusters are removed.
+func Remove(target string, clusters ...string) error {
+	kubeconfigFile, err := targetKubeConfigFile(target)
+	if err != nil {
+		return err
+	}
+
+	var removed []PrunedEntry
+	err = updateKubeConfig(target, kubeconfigFile, func(kconfig []byte) ([]byte, error) {
+		out, r, err := handleRemove(kconfig, clusters)
+		if err != nil || len(r) == 0 {
+			return nil, err
+		}
+		removed = r
+		return out, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(removed) == 0 {
+		log.Info("no contexts written by vaultpal found")
+		return nil
+	}
+
+	for _, r := range removed {
+		log.WithFields(log.Fields{
+			"Kind": r.Kind,
+			"Name": r.Name,
+		}).Info("removed")
+	}
+	return nil
+}
+
+func handleRemove(kconfig []byte, clusters []string) ([]byte, []PrunedEntry, error) {
+	k8, err := parseKubeConfig(kconfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	removed := []PrunedEntry{}
+	removedUsers := map[string]bool{}
+	removedClusters := map[string]bool{}
+	contexts := []ContextEntry{}
+	for _, ce := range k8.Contexts {
+		m := ce.Context.Metadata()
+		if m == nil || (len(clusters) > 0 && !contains(clusters, m.Cluster)) {
+			contexts = append(contexts, ce)
+			continue
+		}
+		removed = append(removed, PrunedEntry{Kind: "context", Name: ce.Name})
+		removedUsers[ce.Context.User] = true
+		removedClusters[ce.Context.Cluster] = true
+		if k8.CurrentContext == ce.Name {
+			k8.CurrentContext = ""
+		}
+	}
+
+	// users and clusters are kept, as long as any other context references them
+	for _, ce := range contexts {
+		delete(removedUsers, ce.Context.User)
+		delete(removedClusters, ce.Context.Cluster)
+	}
+
+	users := []UserEntry{}
+	for _, ue := range k8.Users {
+		if removedUsers[ue.Name] {
+			removed = append(removed, PrunedEntry{Kind: "user", Name: ue.Name})
+			continue
+		}
+		users = append(users, ue)
+	}
+
+	clusterEntries := []ClusterEntry{}
+	for _, ce := range k8.Clusters {
+		if removedClusters[ce.Name] {
+			removed = append(removed, PrunedEntry{Kind: "cluster", Name: ce.Name})
+			continue
+		}
+		clusterEntries = append(clusterEntries, ce)
+	}
+
+	k8.Contexts = contexts
+	k8.Users = users
+	k8.Clusters = clusterEntries
+
+	out, err := yaml.Marshal(k8)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "cannot marshal kubeconfig file")
+	}
+	return out, removed, nil
+}
@@ -0,0 +1,207 @@
+package kube
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	u "github.com/dbschenker/vaultpal/internal/testutil"
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestStandardKubeConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing")
+	existing := filepath.Join(dir, "existing")
+	if err := os.WriteFile(existing, []byte{}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		kubeconfig string
+		want       string
+	}{
+		{name: "first existing file", kubeconfig: strings.Join([]string{missing, existing}, string(os.PathListSeparator)), want: existing},
+		{name: "first file if none exists", kubeconfig: strings.Join([]string{missing, missing + "2"}, string(os.PathListSeparator)), want: missing},
+		{name: "empty entries", kubeconfig: string(os.PathListSeparator) + existing, want: existing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv(ENV_KUBECONFIG, tt.kubeconfig)
+			defer os.Unsetenv(ENV_KUBECONFIG)
+
+			got, err := standardKubeConfigFile()
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestWriteKubeconfigTarget(t *testing.T) {
+	vm := u.NewVaultServerMock(t)
+	defer vm.CloseServer()
+	os.Setenv(api.EnvVaultAddress, vm.Server.URL)
+	os.Setenv(api.EnvVaultToken, "1234")
+	vm.ServeMocks[PATH_LOOKUP_SELF] = (&mockData{identity: "smurf"}).mockTokenLookupSelf
+	vm.ServeMocks[PATH_KV_MOUNT] = (&mockData{kvVersion: "2"}).mockKVMount
+	vm.ServeMocks[PATH_BRO_CONFIG_BASE+"foreign"] = (&mockData{clusterName: "foreign", pkiName: "k8s-pki", serverURL: "foreign.tsc.sh"}).mockReadPalConfig
+	cert, key := newTestCertificate(t, "smurf", time.Now().Add(time.Hour))
+	vm.ServeMocks[issueCertPath("k8s-pki", "master")] = (&mockData{issuingCa: CA, cert: cert, privateKey: key}).mockIssueCert
+
+	// the cluster has the same name as an entry of the user, which must not be clobbered
+	kubeC, _, err := renderKubeconfig([]byte(FOREIGN_KUBECONFIG), "foreign", "master", WriteOptions{Target: TargetKubeconfig, Prune: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Log(string(kubeC))
+
+	got, err := parseKubeConfig(kubeC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	foreign, err := parseKubeConfig([]byte(FOREIGN_KUBECONFIG))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, foreign.Clusters[0], got.Clusters[0])
+	assert.Equal(t, foreign.Contexts[0], got.Contexts[0])
+	assert.Equal(t, foreign.Users[0], got.Users[0])
+
+	assert.Equal(t, "vaultpal-foreign", got.CurrentContext)
+	assert.Equal(t, "vaultpal-foreign", got.Clusters[len(got.Clusters)-1].Name)
+	assert.Equal(t, Context{
+		Cluster:    "vaultpal-foreign",
+		Namespace:  "master",
+		User:       "vaultpal-foreign_smurf",
+		Extensions: metadataExtensions("foreign", "master"),
+	}, got.Contexts[1].Context)
+	assert.Equal(t, "vaultpal-foreign_smurf", got.Users[1].Name)
+
+	// removing the vaultpal entries restores the entries of the user
+	out, removed, err := handleRemove(kubeC, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []PrunedEntry{
+		{Kind: "context", Name: "vaultpal-foreign"},
+		{Kind: "user", Name: "vaultpal-foreign_smurf"},
+		{Kind: "cluster", Name: "vaultpal-foreign"},
+	}, removed)
+
+	got, err = parseKubeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, foreign.Clusters, got.Clusters)
+	assert.Equal(t, foreign.Contexts, got.Contexts)
+	assert.Equal(t, foreign.Users, got.Users)
+	assert.Equal(t, "", got.CurrentContext)
+}
+
+func TestRemoveCluster(t *testing.T) {
+	raw := staleKubeConfig(t)
+
+	out, removed, err := handleRemove(raw, []string{"lukas"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, []PrunedEntry{
+		{Kind: "context", Name: "lukas"},
+		{Kind: "user", Name: "lukas_smurf"},
+		{Kind: "cluster", Name: "lukas"},
+	}, removed)
+
+	got, err := parseKubeConfig(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, got.Contexts, 2)
+	assert.Equal(t, "emma", got.CurrentContext)
+}
+
+func TestUpdateKubeConfigBackup(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config")
+	err := os.WriteFile(file, []byte("old"), 0640)
+	assert.NoError(t, err)
+
+	err = updateKubeConfig(TargetKubeconfig, file, func(kconfig []byte) ([]byte, error) {
+		assert.Equal(t, "old", string(kconfig))
+		return []byte("new"), nil
+	})
+	assert.NoError(t, err)
+
+	got, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(got))
+	backup, err := os.ReadFile(file + backupSuffix)
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(backup))
+
+	// the permissions of the replaced file are kept
+	fi, err := os.Stat(file)
+	assert.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), fi.Mode().Perm())
+}
+
+func TestUpdateKubeConfigSymlink(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "dotfiles", "kubeconfig")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(file), 0700))
+	assert.NoError(t, os.WriteFile(file, []byte("old"), 0600))
+	link := filepath.Join(dir, "config")
+	if err := os.Symlink(file, link); err != nil {
+		t.Skipf("cannot create symlink: %s", err)
+	}
+
+	err := updateKubeConfig(TargetKubeconfig, link, func(kconfig []byte) ([]byte, error) {
+		assert.Equal(t, "old", string(kconfig))
+		return []byte("new"), nil
+	})
+	assert.NoError(t, err)
+
+	// the symlink is kept and the file it points to is replaced
+	fi, err := os.Lstat(link)
+	assert.NoError(t, err)
+	assert.True(t, fi.Mode()&os.ModeSymlink != 0, "symlink is kept")
+	got, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	assert.Equal(t, "new", string(got))
+	backup, err := os.ReadFile(file + backupSuffix)
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(backup))
+}
+
+func TestUpdateKubeConfigConcurrent(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := updateKubeConfig(TargetVaultpal, file, func(kconfig []byte) ([]byte, error) {
+				k8, err := parseKubeConfig(kconfig)
+				if err != nil {
+					return nil, err
+				}
+				k8.Contexts = upsertContextEntry(k8.Contexts, ContextEntry{Name: fmt.Sprintf("ctx-%d", i)})
+				return yaml.Marshal(k8)
+			})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	raw, err := os.ReadFile(file)
+	assert.NoError(t, err)
+	k8, err := parseKubeConfig(raw)
+	assert.NoError(t, err)
+	assert.Len(t, k8.Contexts, 10, "no update is lost")
+}
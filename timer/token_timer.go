@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/dbschenker/vaultpal/timer/cache"
+	"github.com/dbschenker/vaultpal/vault"
 	"math"
 	"net"
 	"net/url"
@@ -26,41 +27,62 @@ const (
 	NetworkTimeout = 275 * time.Millisecond
 )
 
-func Timer(bash bool, query bool, clear bool) {
+// ErrNoToken is returned by Timer, if there is no vault token
+var ErrNoToken = errors.New("token empty")
+
+// ErrTokenExpired is returned by Timer, if the vault token is expired or invalid
+var ErrTokenExpired = errors.New("token expired")
+
+// TimeoutError is returned by Timer, if vault at Address does not answer the token lookup in time,
+// e.g. because VAULT_ADDR points to a vault of another network
+type TimeoutError struct {
+	Address string
+	Err     error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("unset your VAULT_ADDR variable, %s can't be reached", e.Address)
+}
+
+func (e *TimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// Timer prints the remaining TTL of the vault token. Nothing is printed, if VAULT_ADDR is unset.
+func Timer(bash bool, query bool, clear bool) error {
 
 	if bash {
 		PromptString()
-		return
+		return nil
 	}
 
 	if clear {
 		cache.Clear()
-		return
+		return nil
 	}
 
 	vaultAddr := os.Getenv(api.EnvVaultAddress)
 	if vaultAddr == "" {
-		os.Exit(0)
+		return nil
 	}
 
 	token, err := currentToken()
 	if err != nil {
-		_, _ = fmt.Fprintf(os.Stderr, "get token failed: %e\n", err)
-		os.Exit(0)
+		return fmt.Errorf("get token failed: %w", err)
 	}
 	if token == "" {
-		_, _ = fmt.Fprintf(os.Stderr, "token empty\n")
-		os.Exit(0)
+		return ErrNoToken
 	}
 
-	ttl := vaultTokenTTL(vaultAddr, token)
-	if ttl > 0 {
-		output(ttl, false, label(vaultAddr), query)
-		os.Exit(0)
-	} else {
-		os.Exit(1)
+	ttl, err := vaultTokenTTL(vaultAddr, token)
+	if err != nil {
+		return err
 	}
-
+	if ttl <= 0 {
+		return ErrTokenExpired
+	}
+	output(ttl, false, label(vaultAddr), query)
+	return nil
 }
 
 func currentToken() (string, error) {
@@ -96,7 +118,7 @@ func label(address string) string {
 	}
 }
 
-func vaultTokenTTL(endpoint string, currentToken string) time.Duration {
+func vaultTokenTTL(endpoint string, currentToken string) (time.Duration, error) {
 	cached, err := cache.Read(endpoint)
 	if err == nil && cached.Address == endpoint && cached.Token == currentToken {
 		// use cache and skip expensive vault network access
@@ -110,14 +132,13 @@ func vaultTokenTTL(endpoint string, currentToken string) time.Duration {
 				Updated: now,
 				TTL:     newTTL,
 			})
-			return newTTL
+			return newTTL, nil
 		}
 	}
 
 	if err := verifyNetwork(endpoint); err != nil {
 		// no network: no vault
-		_, _ = fmt.Fprintf(os.Stderr, "no network: no vault\n")
-		os.Exit(0)
+		return 0, &vault.UnreachableError{Address: endpoint, Err: err}
 	}
 
 	// expensive
@@ -127,19 +148,19 @@ func vaultTokenTTL(endpoint string, currentToken string) time.Duration {
 		Timeout: 1300 * time.Millisecond,
 	})
 	if err != nil {
-		os.Exit(1)
+		return 0, &vault.ClientError{Err: err}
 	}
 	client.SetToken(currentToken)
 	t, err := client.Auth().Token().LookupSelf()
 	if err != nil {
 		if errors.Is(err, context.DeadlineExceeded) {
-			_, _ = fmt.Fprintf(os.Stderr, "unset your VAULT_ADDR variable, %s can't be reached \n", endpoint)
+			return 0, &TimeoutError{Address: endpoint, Err: err}
 		}
-		return 0
+		return 0, nil
 	}
 	ttl, err := t.TokenTTL()
 	if err != nil {
-		return 0
+		return 0, nil
 	}
 
 	_ = cache.Write(endpoint, cache.Cache{
@@ -148,7 +169,7 @@ func vaultTokenTTL(endpoint string, currentToken string) time.Duration {
 		Updated: time.Now(),
 		TTL:     ttl,
 	})
-	return ttl
+	return ttl, nil
 }
 
 func verifyNetwork(endpoint string) error {
@@ -168,40 +189,45 @@ func verifyNetwork(endpoint string) error {
 	return nil
 }
 
+// TTLLevel rates the remaining ttl in relation to maxTTL as Green, Yellow or Red
+func TTLLevel(ttl time.Duration, maxTTL time.Duration) string {
+	factor := math.Floor(ttl.Seconds() / maxTTL.Seconds() * 100)
+	switch {
+	case factor >= 50:
+		return Green
+	case factor >= 10:
+		return Yellow
+	default:
+		return Red
+	}
+}
+
+// LevelColor returns the color to print a TTL of the given level
+func LevelColor(level string) *color.Color {
+	switch level {
+	case Green:
+		return color.New(color.FgGreen)
+	case Yellow:
+		return color.New(color.FgYellow)
+	default:
+		return color.New(color.FgRed)
+	}
+}
+
 func output(ttl time.Duration, carriageReturn bool, label string, query bool) {
 	if ttl <= 0 {
 		fmt.Printf("")
 		return
 	}
-	factor := math.Floor(ttl.Seconds() / UsualMaxTTL.Seconds() * 100)
 	var fmtCR = "\r"
 	if !carriageReturn {
 		fmtCR = ""
 	}
-	info := color.New(color.FgGreen)
-	warn := color.New(color.FgYellow)
-	crit := color.New(color.FgRed)
 	msg := fmt.Sprintf("%s%s%02dm ", fmtCR, label, ttl/time.Minute)
-	switch {
-	case factor >= 50:
-		if query {
-			fmt.Println(Green)
-		} else {
-			_, _ = info.Println(msg)
-		}
-
-	case factor <= 50 && factor >= 10:
-		if query {
-			fmt.Println(Yellow)
-		} else {
-			_, _ = warn.Println(msg)
-		}
-
-	default:
-		if query {
-			fmt.Println(Red)
-		} else {
-			_, _ = crit.Println(msg)
-		}
+	level := TTLLevel(ttl, UsualMaxTTL)
+	if query {
+		fmt.Println(level)
+	} else {
+		_, _ = LevelColor(level).Println(msg)
 	}
 }
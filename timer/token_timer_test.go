@@ -1,6 +1,7 @@
 package timer
 
 import (
+	"github.com/dbschenker/vaultpal/vault"
 	"github.com/stretchr/testify/assert"
 	"os"
 	"testing"
@@ -18,6 +19,19 @@ func TestLabel(t *testing.T) {
 	assert.Contains(t, label("https://nonsense"), "?")
 }
 
+func TestTimerWithoutVault(t *testing.T) {
+	_ = os.Unsetenv("VAULT_ADDR")
+	assert.NoError(t, Timer(false, false, false))
+}
+
+func TestVaultTokenTTLUnreachable(t *testing.T) {
+	_, err := vaultTokenTTL("https://vault.invalid", "1234")
+	var unreachable *vault.UnreachableError
+	if assert.ErrorAs(t, err, &unreachable) {
+		assert.Equal(t, "https://vault.invalid", unreachable.Address)
+	}
+}
+
 //
 //import (
 //	"bytes"
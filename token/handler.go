@@ -35,7 +35,7 @@ func createRoleToken(role string) (*api.SecretAuth, error) {
 
 	client, err := vault.NewClient()
 	if err != nil {
-		log.Fatal("error creating vault api client: " + err.Error())
+		return nil, errors.Wrap(err, "error creating vault api client")
 	}
 
 	user, err := vault.GetIdentityName(client)
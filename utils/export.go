@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Dialects of the environment variable assignments printed for the host shell
+const (
+	ShellPosix      = "posix"
+	ShellCmd        = "cmd"
+	ShellPowerShell = "powershell"
+)
+
+// EnvVar is an environment variable to export
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// DetectExportShell returns the dialect of the shell the export lines are printed for.
+// On windows the host shell is detected, everywhere else posix shells are assumed.
+func DetectExportShell() (string, error) {
+	if runtime.GOOS != "windows" {
+		return ShellPosix, nil
+	}
+
+	log.Debugln("OS Detected: Running on Windows!")
+	hostShell, err := GetHostShell()
+	if err != nil {
+		log.Errorln("Failed to get host shell: ", err)
+	}
+	log.Debugln("Host Shell: ", hostShell)
+	switch hostShell {
+	case "cmd":
+		log.Debugln("Shell Host Detected: Running on CMD!")
+		return ShellCmd, nil
+	case "powershell":
+		log.Debugln("Shell Host Detected: Running on PowerShell!")
+		return ShellPowerShell, nil
+	default:
+		return "", errors.New("Failed to detect host shell!")
+	}
+}
+
+// ExportLines renders the assignments of vars in the dialect of shell, one per line.
+// The values are quoted, so the shell takes them literally.
+func ExportLines(shell string, vars ...EnvVar) string {
+	lines := make([]string, 0, len(vars))
+	for _, v := range vars {
+		switch shell {
+		case ShellCmd:
+			lines = append(lines, fmt.Sprintf("set \"%s=%s\"", v.Name, v.Value))
+		case ShellPowerShell:
+			lines = append(lines, fmt.Sprintf("$env:%s='%s'", v.Name, strings.ReplaceAll(v.Value, "'", "''")))
+		default:
+			lines = append(lines, fmt.Sprintf("export %s=%s", v.Name, quotePosix(v.Value)))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// quotePosix quotes s for posix shells, unless it consists of characters without special meaning only
+func quotePosix(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("_-+=.,:/@%", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ExportEnv renders the assignments of vars for the detected host shell
+func ExportEnv(vars ...EnvVar) (string, error) {
+	shell, err := DetectExportShell()
+	if err != nil {
+		return "", err
+	}
+	return ExportLines(shell, vars...), nil
+}
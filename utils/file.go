@@ -0,0 +1,123 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+const lockSuffix = ".lock"
+
+// FileUpdateOptions controls how UpdateFile replaces a file
+type FileUpdateOptions struct {
+	// Perm of the file, if it does not exist yet. The permissions of an existing file are kept.
+	Perm os.FileMode
+	// BackupSuffix keeps the previous content as file+BackupSuffix, no backup is written if empty
+	BackupSuffix string
+}
+
+// UpdateFile replaces the content of file with the result of update, which is called with the current content,
+// nil if the file does not exist. The file is locked against other vaultpal processes from reading until writing,
+// and replaced atomically by renaming a temporary file, so readers never see a truncated file.
+// If update returns nil, the file is left unchanged. If file is a symlink, the file it points to is replaced,
+// so the symlink is kept.
+func UpdateFile(file string, opts FileUpdateOptions, update func(old []byte) ([]byte, error)) error {
+	if resolved, err := filepath.EvalSymlinks(file); err == nil {
+		file = resolved
+	} else if !os.IsNotExist(err) {
+		return errors.Wrapf(err, "cannot resolve [%s]", file)
+	}
+
+	err := os.MkdirAll(filepath.Dir(file), 0700)
+	if err != nil {
+		return errors.Wrapf(err, "cannot create dir [%s]", filepath.Dir(file))
+	}
+
+	unlock, err := LockFile(file)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	perm := opts.Perm
+	old, err := os.ReadFile(file)
+	switch {
+	case err == nil:
+		if fi, err := os.Stat(file); err == nil {
+			perm = fi.Mode().Perm()
+		}
+	case os.IsNotExist(err):
+		old = nil
+	default:
+		return errors.Wrapf(err, "cannot read [%s]", file)
+	}
+
+	out, err := update(old)
+	if err != nil {
+		return err
+	}
+	if out == nil {
+		return nil
+	}
+
+	if opts.BackupSuffix != "" && len(old) > 0 {
+		err = WriteFileAtomic(file+opts.BackupSuffix, old, perm)
+		if err != nil {
+			return errors.Wrapf(err, "cannot write backup of [%s]", file)
+		}
+	}
+
+	return WriteFileAtomic(file, out, perm)
+}
+
+// WriteFileAtomic writes data to a temporary file next to file and renames it to file
+func WriteFileAtomic(file string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(file), "."+filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return errors.Wrapf(err, "cannot create temporary file for [%s]", file)
+	}
+	// a no-op after the rename
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrapf(err, "cannot write temporary file for [%s]", file)
+	}
+
+	err = os.Rename(tmp.Name(), file)
+	if err != nil {
+		return errors.Wrapf(err, "cannot replace [%s]", file)
+	}
+	return nil
+}
+
+// LockFile takes an exclusive advisory lock on file, blocking until it is released by other processes.
+// The lock is held on file+".lock", because file itself is replaced on every write.
+func LockFile(file string) (func(), error) {
+	lockFile := file + lockSuffix
+	f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, errors.Wrapf(err, "cannot open lock file [%s]", lockFile)
+	}
+
+	err = lock(f)
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrapf(err, "cannot lock [%s]", file)
+	}
+
+	return func() {
+		_ = unlock(f)
+		_ = f.Close()
+	}, nil
+}
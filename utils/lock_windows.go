@@ -0,0 +1,22 @@
+//go:build windows
+
+package utils
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// the whole file is locked, windows locks byte ranges
+const lockRange = ^uint32(0)
+
+func lock(f *os.File) error {
+	ol := new(windows.Overlapped)
+	return windows.LockFileEx(windows.Handle(f.Fd()), windows.LOCKFILE_EXCLUSIVE_LOCK, 0, lockRange, lockRange, ol)
+}
+
+func unlock(f *os.File) error {
+	ol := new(windows.Overlapped)
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, lockRange, lockRange, ol)
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Output formats supported by list and describe commands
+const (
+	OutputTable = "table"
+	OutputJSON  = "json"
+	OutputYAML  = "yaml"
+)
+
+var OutputFormats = []string{OutputTable, OutputJSON, OutputYAML}
+
+// WriteOutput writes v to w in the given format. The table format is rendered by table,
+// with columns separated by tabs.
+func WriteOutput(w io.Writer, format string, v interface{}, table func(w io.Writer)) error {
+	switch format {
+	case OutputTable, "":
+		tw := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+		table(tw)
+		return tw.Flush()
+	case OutputJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case OutputYAML:
+		out, err := yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(out)
+		return err
+	default:
+		return fmt.Errorf("unsupported output format [%s], use one of %v", format, OutputFormats)
+	}
+}
@@ -19,24 +19,24 @@ func NewClient() (*api.Client, error) {
 	if token == "" {
 		tokenHelper, err := cliconfig.DefaultTokenHelper()
 		if err != nil {
-			return nil, fmt.Errorf("error getting token helper: %s", err)
+			return nil, &ClientError{Err: fmt.Errorf("error getting token helper: %s", err)}
 		}
 		token, err = tokenHelper.Get()
 		if err != nil {
-			return nil, fmt.Errorf("error getting token: %s", err)
+			return nil, &ClientError{Err: fmt.Errorf("error getting token: %s", err)}
 		}
 	}
 
 	_, err := url.ParseRequestURI(vaultHost)
 	if err != nil {
-		return nil, errors.Wrap(err, "invalid vault address provided. Check environment variable [VAULT_ADDR]")
+		return nil, &ClientError{Err: errors.Wrap(err, "invalid vault address provided. Check environment variable [VAULT_ADDR]")}
 	}
 
 	client, err := api.NewClient(&api.Config{
 		Address: vaultHost,
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "error creating vault client")
+		return nil, &ClientError{Err: errors.Wrap(err, "error creating vault client")}
 	}
 
 	client.SetToken(strings.TrimSpace(token))
@@ -47,7 +47,7 @@ func NewClient() (*api.Client, error) {
 func GetIdentityName(client *api.Client) (*string, error) {
 	self, err := client.Auth().Token().LookupSelf()
 	if err != nil {
-		return nil, errors.Wrap(err, "error lookup own identity")
+		return nil, errors.Wrap(CheckReachable(client, err), "error lookup own identity")
 	}
 
 	name, ok := self.Data["display_name"]
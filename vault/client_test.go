@@ -1,8 +1,13 @@
 package vault
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/stretchr/testify/assert"
 )
 
 func TestVaultTokenEnv(t *testing.T) {
@@ -17,3 +22,32 @@ func TestVaultTokenEnv(t *testing.T) {
 		t.Errorf("Expected %s got %s", expect, client.Token())
 	}
 }
+
+func TestNewClientError(t *testing.T) {
+	_ = os.Setenv("VAULT_ADDR", "no-url")
+	_ = os.Setenv("VAULT_TOKEN", "1234")
+	defer func() {
+		_ = os.Unsetenv("VAULT_ADDR")
+		_ = os.Unsetenv("VAULT_TOKEN")
+	}()
+
+	_, err := NewClient()
+	var clientErr *ClientError
+	assert.ErrorAs(t, err, &clientErr)
+}
+
+func TestCheckReachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+	client, err := api.NewClient(&api.Config{Address: server.URL, MaxRetries: 0})
+	assert.NoError(t, err)
+
+	_, err = GetIdentityName(client)
+	var unreachable *UnreachableError
+	assert.ErrorAs(t, err, &unreachable)
+	assert.Equal(t, server.URL, unreachable.Address)
+
+	assert.Nil(t, CheckReachable(client, nil))
+	responded := &api.ResponseError{StatusCode: http.StatusForbidden}
+	assert.Equal(t, responded, CheckReachable(client, responded))
+}
@@ -0,0 +1,46 @@
+package vault
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/pkg/errors"
+)
+
+// ClientError is returned by NewClient, if no vault api client can be created, e.g. because VAULT_ADDR is invalid
+type ClientError struct {
+	Err error
+}
+
+func (e *ClientError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ClientError) Unwrap() error {
+	return e.Err
+}
+
+// UnreachableError is returned, if the vault server at Address cannot be reached
+type UnreachableError struct {
+	Address string
+	Err     error
+}
+
+func (e *UnreachableError) Error() string {
+	return fmt.Sprintf("vault at %s is unreachable: %v", e.Address, e.Err)
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// CheckReachable turns the error of a request to client into an UnreachableError, if the request did not reach vault.
+// Errors responded by vault are returned as they are.
+func CheckReachable(client *api.Client, err error) error {
+	var urlErr *url.Error
+	if err != nil && errors.As(err, &urlErr) {
+		return &UnreachableError{Address: client.Address(), Err: err}
+	}
+	return err
+}